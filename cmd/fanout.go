@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/arhyth/mitch"
+	"github.com/arhyth/mitch/internal"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+// fanoutTarget is a single host and database the migration set is applied to
+type fanoutTarget struct {
+	host     string
+	database string
+}
+
+func (t fanoutTarget) String() string {
+	return fmt.Sprintf("%s/%s", t.host, t.database)
+}
+
+// isFanout reports whether the migration set is applied to several hosts or databases
+func isFanout(c *cli.Context) bool {
+	if c.IsSet("databases") || c.IsSet("databases-from") {
+		return true
+	}
+	return flagOrEnv(c, "hosts", mitch.EnvFanoutHosts) != "" ||
+		flagOrEnv(c, "hosts-from-cluster", mitch.EnvFanoutCluster) != ""
+}
+
+// runFanout applies the migration set to every host selected by `--hosts` and
+// `--hosts-from-cluster`, and every database selected by `--databases` and
+// `--databases-from`. Every target keeps its own version table.
+func runFanout(c *cli.Context, dbURL string) error {
+	opts, err := mitch.ParseDBURL(dbURL)
+	if err != nil {
+		return err
+	}
+
+	if c.String("dump-schema") != "" {
+		log.Warn().Msg("mitch: --dump-schema is not supported with multiple hosts or databases, skipping")
+	}
+
+	hosts, databases, err := discoverTargets(c, opts)
+	if err != nil {
+		return err
+	}
+	var targets []string
+	byName := make(map[string]fanoutTarget)
+	for _, host := range hosts {
+		for _, dbName := range databases {
+			t := fanoutTarget{host: host, database: dbName}
+			targets = append(targets, t.String())
+			byName[t.String()] = t
+		}
+	}
+	if len(targets) == 0 {
+		log.Warn().Msg("mitch: no hosts or databases selected, nothing to do")
+		return nil
+	}
+
+	dirFs := migrationFS()
+	var reportsMu sync.Mutex
+	reports := make([]*internal.Report, 0, len(targets))
+	cfg := internal.FanoutConfig{
+		Concurrency:     c.Int("concurrency"),
+		ContinueOnError: c.Bool("continue-on-error"),
+	}
+	results, err := internal.Fanout(c.Context, targets, cfg, func(ctx context.Context, name string) (int64, error) {
+		target := byName[name]
+		targetOpts := *opts
+		targetOpts.Addr = strings.Split(target.host, ",")
+		targetOpts.Auth.Database = target.database
+		conn, err := mitch.ConnectOptions(&targetOpts)
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+
+		ropts := runnerOptions(c)
+		if versionDB := flagOrEnv(c, "version-db", mitch.EnvVersionDB); versionDB != "" && len(databases) > 1 {
+			// databases sharing a metadata database each need their own table
+			table := flagOrEnv(c, "version-table", mitch.EnvVersionTable)
+			if table == "" {
+				table = mitch.VersionTable
+			}
+			ropts = append(ropts, internal.WithVersionTable(fmt.Sprintf("%s_%s", table, target.database)))
+		}
+		runner := internal.NewRunner(dirFs, conn, ropts...)
+		err = migrate(ctx, c, runner)
+		if report := runner.Report(); report != nil {
+			report.Target = name
+			reportsMu.Lock()
+			reports = append(reports, report)
+			reportsMu.Unlock()
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		ver, err := runner.GetCurrentVersion(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return ver.ID, nil
+	})
+	internal.LogSummary(results)
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Target < reports[j].Target
+	})
+	if rerr := writeReports(c, true, reports...); rerr != nil {
+		return errors.Join(err, rerr)
+	}
+	if err != nil {
+		return err
+	}
+
+	return internal.CheckConverged(results)
+}
+
+// discoverTargets lists hosts and databases to fan out to. Without host flags,
+// the connection addresses are used as a single target, keeping failover
+// between them. Without database flags, only the connection database is used.
+func discoverTargets(c *cli.Context, opts *clickhouse.Options) (hosts, databases []string, err error) {
+	if list := flagOrEnv(c, "hosts", mitch.EnvFanoutHosts); list != "" {
+		for _, host := range strings.Split(list, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				hosts = append(hosts, withDefaultPort(host, opts))
+			}
+		}
+	}
+	databases = c.StringSlice("databases")
+
+	cluster := flagOrEnv(c, "hosts-from-cluster", mitch.EnvFanoutCluster)
+	query := c.String("databases-from")
+	if cluster != "" || query != "" {
+		conn, err := mitch.ConnectOptions(opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer conn.Close()
+
+		if cluster != "" {
+			found, err := internal.ListClusterHosts(c.Context, conn, cluster)
+			if err != nil {
+				return nil, nil, err
+			}
+			if opts.Protocol == clickhouse.HTTP {
+				// system.clusters lists native ports only, assume
+				// every host serves HTTP on the same port as the connection
+				_, port, _ := net.SplitHostPort(opts.Addr[0])
+				for i, addr := range found {
+					host, _, _ := net.SplitHostPort(addr)
+					found[i] = net.JoinHostPort(host, port)
+				}
+			}
+			hosts = append(hosts, found...)
+		}
+		if query != "" {
+			found, err := internal.ListDatabases(c.Context, conn, query)
+			if err != nil {
+				return nil, nil, err
+			}
+			databases = append(databases, found...)
+		}
+	}
+
+	if len(hosts) == 0 {
+		hosts = []string{strings.Join(opts.Addr, ",")}
+	}
+	if len(databases) == 0 && !c.IsSet("databases") && query == "" {
+		databases = []string{opts.Auth.Database}
+	}
+	return hosts, databases, nil
+}
+
+func withDefaultPort(host string, opts *clickhouse.Options) string {
+	if strings.Contains(host, ":") {
+		return host
+	}
+	return net.JoinHostPort(host, mitch.DefaultPortFor(opts.Protocol, opts.TLS != nil))
+}
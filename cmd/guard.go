@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/arhyth/mitch"
+	"github.com/arhyth/mitch/internal"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// promptMu serialises confirmations of concurrent fan-out targets
+	promptMu sync.Mutex
+	stdin    = bufio.NewReader(os.Stdin)
+)
+
+// isProtected reports whether `--protected` or the env marks the environment
+// as protected. An invalid env value is treated as protected.
+func isProtected(c *cli.Context) bool {
+	if c.Bool("protected") {
+		return true
+	}
+	protected, err := mitch.IsProtected()
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msg("invalid protected setting, treating environment as protected")
+		return true
+	}
+	return protected
+}
+
+// confirmDestructive asks on the terminal whether destructive statements may
+// run, refusing them when stdin is not a terminal
+func confirmDestructive(ctx context.Context, change internal.DestructiveChange) error {
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return internal.RefuseDestructive(ctx, change)
+	}
+
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	fmt.Fprintf(os.Stderr, "%s\nRun these statements? Type yes to continue: ", change)
+	answer, err := stdin.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read confirmation: %w", err)
+	}
+	if strings.TrimSpace(answer) != "yes" {
+		return fmt.Errorf("%w: %s", mitch.ErrDestructiveChange, change.Source)
+	}
+	log.Warn().
+		Str("source", change.Source).
+		Msg("mitch: destructive statements confirmed")
+	return nil
+}
@@ -7,16 +7,19 @@ import (
 	"os"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/arhyth/mitch"
 )
 
 const envTemplate = `# ClickHouse Configuration
 CLICKHOUSE_HOST=clickhouse-server
 CLICKHOUSE_MIGRATION_DIR=/app/testdata/migrations/
 CLICKHOUSE_DB={{ .DBName }}
+CLICKHOUSE_PROTOCOL={{ .Protocol }}
 `
 
 type EnvTempl struct {
-	DBName string
+	DBName   string
+	Protocol string
 }
 
 // dbHelper wraps *sql.DB and provides helper methods
@@ -26,8 +29,17 @@ type dbHelper struct {
 
 // NewDBHelper initializes a new dbHelper
 func NewDBHelper() (*dbHelper, error) {
+	protocol, err := mitch.GetProtocol()
+	if err != nil {
+		return nil, err
+	}
+	addr := "clickhouse-server:" + mitch.DefaultPort
+	if protocol == clickhouse.HTTP {
+		addr = "clickhouse-server:" + mitch.DefaultHTTPPort
+	}
 	opts := &clickhouse.Options{
-		Addr: []string{"clickhouse-server:9000"},
+		Protocol: protocol,
+		Addr:     []string{addr},
 		Auth: clickhouse.Auth{
 			Database: "default",
 			Username: "default",
@@ -37,6 +49,11 @@ func NewDBHelper() (*dbHelper, error) {
 	return &dbHelper{db: db}, nil
 }
 
+// newDBHelperFor wraps an existing connection, eg. one configured from env vars
+func newDBHelperFor(db *sql.DB) *dbHelper {
+	return &dbHelper{db: db}
+}
+
 // CreateDatabase creates the database specified in the config
 func (d *dbHelper) CreateDatabase(dbName string) error {
 	query := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s;", dbName)
@@ -68,6 +85,10 @@ func createEnvFile(path, dbName string) error {
 	}
 	defer outputFile.Close()
 
-	err = tmpl.Execute(outputFile, EnvTempl{DBName: dbName})
+	protocol, err := mitch.GetProtocol()
+	if err != nil {
+		return err
+	}
+	err = tmpl.Execute(outputFile, EnvTempl{DBName: dbName, Protocol: protocol.String()})
 	return err
 }
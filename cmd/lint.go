@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/arhyth/mitch"
+	"github.com/arhyth/mitch/internal"
+	"github.com/urfave/cli/v2"
+)
+
+// lint checks the migration directory and fails on error findings, or on
+// warnings too with `--strict`
+func lint(c *cli.Context) error {
+	cfg := internal.LintConfig{
+		Severities: make(map[string]internal.Severity),
+		Cluster:    c.Bool("cluster"),
+	}
+	if disable := flagOrEnv(c, "disable", mitch.EnvLintDisable); disable != "" {
+		for _, id := range strings.Split(disable, ",") {
+			cfg.Disabled = append(cfg.Disabled, strings.TrimSpace(id))
+		}
+	}
+	for _, override := range c.StringSlice("severity") {
+		id, severity, ok := strings.Cut(override, "=")
+		if !ok {
+			return fmt.Errorf("%w: %q, expected rule=severity", mitch.ErrInvalidSeverity, override)
+		}
+		cfg.Severities[strings.TrimSpace(id)] = internal.Severity(strings.TrimSpace(severity))
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	dir := migrationDir()
+	ms, err := internal.CollectLintable(os.DirFS(dir))
+	if err != nil {
+		return err
+	}
+	findings := internal.Lint(ms, cfg)
+
+	out := new(strings.Builder)
+	switch format := c.String("format"); format {
+	case "text":
+		err = internal.WriteLintText(out, findings)
+	case "sarif":
+		err = internal.WriteSARIF(out, findings, dir)
+	default:
+		return fmt.Errorf("unknown lint format %q, expected text or sarif", format)
+	}
+	if err != nil {
+		return err
+	}
+	if err := writeOutput(c.String("out"), out.String(), "mitch: wrote lint findings"); err != nil {
+		return err
+	}
+
+	var failed int
+	for _, f := range findings {
+		if f.Severity == internal.SeverityError || (c.Bool("strict") && f.Severity == internal.SeverityWarning) {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d findings", mitch.ErrLintFindings, failed, len(findings))
+	}
+	return nil
+}
@@ -2,7 +2,16 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/arhyth/mitch"
 	"github.com/arhyth/mitch/internal"
@@ -12,6 +21,9 @@ import (
 )
 
 func main() {
+	// secrets are registered as they are resolved, mask them in every log line
+	log.Logger = log.Output(mitch.NewRedactWriter(os.Stderr))
+
 	app := &cli.App{
 		Name:           "mitch",
 		Usage:          "A simple migration tool for Clickhouse",
@@ -22,10 +34,121 @@ func main() {
 				Aliases: []string{"e"},
 				Usage:   "Path to the .env file",
 			},
+			&cli.BoolFlag{
+				Name:  "password-stdin",
+				Usage: "Read the database password from stdin",
+			},
 			&cli.StringFlag{
 				Name:  "rollback",
 				Usage: "Path to the SQL rollback file (optional, triggers rollback mode)",
 			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Roll back irreversible versions, running whatever rollback section they have",
+			},
+			&cli.BoolFlag{
+				Name:  "allow-empty-rollback",
+				Usage: "Treat versions without a rollback section as reversible",
+			},
+			&cli.StringFlag{
+				Name:  "report",
+				Usage: "Write a machine-readable run report in the given format (json)",
+				Action: func(_ *cli.Context, format string) error {
+					// fail before migrating rather than after
+					return checkReportFormat(format)
+				},
+			},
+			&cli.StringFlag{
+				Name:  "report-file",
+				Usage: "File the run report is written to, stdout when not set",
+				Value: "-",
+			},
+			&cli.StringFlag{
+				Name:  "junit",
+				Usage: "Write a JUnit XML report to the given file, one test case per version",
+			},
+			&cli.BoolFlag{
+				Name:  "junit-statements",
+				Usage: "Report every statement as a JUnit test case instead of every version",
+			},
+			&cli.StringFlag{
+				Name:  "dump-schema",
+				Usage: "Write the resulting schema to the given file after migrating or rolling back",
+			},
+			&cli.StringFlag{
+				Name:  "version-table",
+				Usage: fmt.Sprintf("Name of the table tracking applied versions (default %q)", mitch.VersionTable),
+			},
+			&cli.StringFlag{
+				Name:  "version-db",
+				Usage: "Database of the version table (defaults to the connection database)",
+			},
+			&cli.DurationFlag{
+				Name:  "mutation-timeout",
+				Usage: "Fail a statement whose mutations do not finish in time (default waits until they finish)",
+			},
+			&cli.DurationFlag{
+				Name:  "mutation-fail-grace",
+				Usage: "Fail a statement once a mutation keeps failing without progress for this long",
+				Value: internal.DefaultMutationFailGrace,
+			},
+			&cli.StringFlag{
+				Name:  "settings",
+				Usage: "ClickHouse settings of every statement as \"key=value,...\", overridden by settings directives",
+				Action: func(_ *cli.Context, v string) error {
+					_, err := internal.ParseSettings(v)
+					return err
+				},
+			},
+			&cli.DurationFlag{
+				Name:  "statement-timeout",
+				Usage: "Fail a statement that does not finish in time, overridden by timeout directives (default no timeout)",
+			},
+			&cli.IntFlag{
+				Name:  "max-attempts",
+				Usage: "Attempts of idempotent statements and version table queries failing transiently, 1 disables retries",
+				Value: 1,
+			},
+			&cli.DurationFlag{
+				Name:  "retry-backoff",
+				Usage: "Wait before the first retry, doubled for every further retry",
+				Value: internal.DefaultRetryPolicy().Backoff,
+			},
+			&cli.DurationFlag{
+				Name:  "retry-max-backoff",
+				Usage: "Longest wait between retries",
+				Value: internal.DefaultRetryPolicy().MaxBackoff,
+			},
+			&cli.IntSliceFlag{
+				Name:  "retry-code",
+				Usage: "ClickHouse error code to retry besides network errors (default TOO_MANY_PARTS, TIMEOUT_EXCEEDED, KEEPER_EXCEPTION and other transient errors)",
+			},
+			&cli.BoolFlag{
+				Name:  "preflight",
+				Usage: "Check mutations, replication, disks and grants before migrating",
+			},
+			&cli.StringSliceFlag{
+				Name:  "preflight-skip",
+				Usage: "Preflight check to skip (mutations, replication-queue, readonly-replicas, disk-space, grants)",
+			},
+			&cli.Uint64Flag{
+				Name:  "max-replication-queue",
+				Usage: "Largest replication queue of a table the preflight accepts",
+				Value: internal.DefaultPreflightConfig().MaxReplicationQueue,
+			},
+			&cli.Float64Flag{
+				Name:  "min-free-disk",
+				Usage: "Smallest free space ratio of a disk the preflight accepts",
+				Value: internal.DefaultPreflightConfig().MinFreeDiskRatio,
+			},
+			&cli.BoolFlag{
+				Name:  "protected",
+				Usage: "Require confirmation for destructive statements, also set by " + mitch.EnvProtected,
+			},
+			&cli.BoolFlag{
+				Name:  "allow-destructive",
+				Usage: "Run destructive statements in protected environments without confirmation",
+			},
 		},
 		Commands: []*cli.Command{
 			{
@@ -86,61 +209,413 @@ func main() {
 				},
 			},
 			{
-				Name: "run",
-				Args: true,
+				Name:  "verify",
+				Usage: "Apply, roll back and re-apply every version on a scratch database",
+				Args:  true,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "keep",
+						Usage: "Keep the scratch database instead of dropping it",
+					},
+				},
 				Action: func(c *cli.Context) error {
-					configPath := c.String("env")
-					log.Info().
-						Str("path", configPath).
-						Msg("loading env vars from file...")
-					if err := godotenv.Load(configPath); err != nil {
+					if err := loadEnv(c); err != nil {
 						return err
 					}
-
-					rollbackFile := c.String("rollback")
-					dbURL := c.Args().First()
-					migrationDir := os.Getenv(mitch.EnvMigrationDir)
-					if migrationDir == "" {
-						log.Warn().Msgf(
-							"Migration directory env `%s` not set, defaulting to `%s`",
-							mitch.EnvMigrationDir,
-							mitch.DefaultMigrationDir,
-						)
-						migrationDir = mitch.DefaultMigrationDir
+					return withScratchDB(c, "verify", func(conn *sql.DB) error {
+						runner := internal.NewRunner(migrationFS(), conn)
+						err := runner.Verify(c.Context)
+						if rerr := writeReports(c, false, runner.Report()); rerr != nil {
+							return errors.Join(err, rerr)
+						}
+						return err
+					})
+				},
+			},
+			{
+				Name:  "dump-schema",
+				Usage: "Write SHOW CREATE statements of every object in the database",
+				Args:  true,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "out",
+						Usage: "File the schema is written to, stdout when not set",
+						Value: "-",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if err := loadEnv(c); err != nil {
+						return err
 					}
-					if dbURL == "" {
-						log.Warn().Msg("DB URL argument not set, will try env vars...")
+					conn, err := mitch.Connect(c.Args().First())
+					if err != nil {
+						return err
 					}
+					defer conn.Close()
 
-					conn, err := mitch.Connect(dbURL)
+					runner := internal.NewRunner(migrationFS(), conn, runnerOptions(c)...)
+					return dumpSchema(c.Context, runner, c.String("out"))
+				},
+			},
+			{
+				Name:  "drift",
+				Usage: "Compare the database with a scratch database the migrations were applied to",
+				Args:  true,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "Output format, text or json",
+						Value: "text",
+					},
+					&cli.StringFlag{
+						Name:  "dump-dir",
+						Usage: "Also write expected.sql and live.sql schema dumps to the given directory",
+					},
+					&cli.BoolFlag{
+						Name:  "keep",
+						Usage: "Keep the scratch database instead of dropping it",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if err := loadEnv(c); err != nil {
+						return err
+					}
+					conn, err := mitch.Connect(c.Args().First())
 					if err != nil {
 						return err
 					}
+					defer conn.Close()
+					dirFs := migrationFS()
+					live := internal.NewRunner(dirFs, conn, runnerOptions(c)...)
 
-					dirFs := os.DirFS(migrationDir)
-					runner := internal.NewRunner(dirFs, conn)
+					return withScratchDB(c, "drift", func(scratch *sql.DB) error {
+						expected := internal.NewRunner(dirFs, scratch)
+						if err := expected.Migrate(c.Context); err != nil {
+							return err
+						}
+						if dir := c.String("dump-dir"); dir != "" {
+							if err := dumpSchema(c.Context, expected, filepath.Join(dir, "expected.sql")); err != nil {
+								return err
+							}
+							if err := dumpSchema(c.Context, live, filepath.Join(dir, "live.sql")); err != nil {
+								return err
+							}
+						}
 
-					// Rollback mode
-					if rollbackFile != "" {
-						log.Debug().
-							Str("file", rollbackFile).
-							Msg("Running in rollback mode...")
-						if err = runner.Rollback(context.Background(), rollbackFile); err != nil {
+						report, err := internal.DetectDrift(c.Context, expected, live)
+						if err != nil {
+							return err
+						}
+						if err := report.Write(os.Stdout, c.String("format")); err != nil {
+							return err
+						}
+						return report.Err()
+					})
+				},
+			},
+			{
+				Name:  "diff",
+				Usage: "Generate a migration from the difference between the database and a desired schema file",
+				Args:  true,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "to",
+						Usage:    "Desired schema file, eg. written by dump-schema",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "from-migrations",
+						Usage: "Compare against a scratch database the migrations were applied to instead of the live database",
+					},
+					&cli.StringFlag{
+						Name:  "name",
+						Usage: "Name of the generated migration file, after its version prefix",
+						Value: "schema_diff",
+					},
+					&cli.BoolFlag{
+						Name:  "print",
+						Usage: "Print the migration instead of writing it to the migration directory",
+					},
+					&cli.BoolFlag{
+						Name:  "keep",
+						Usage: "Keep the scratch databases instead of dropping them",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if err := loadEnv(c); err != nil {
+						return err
+					}
+					return generateDiff(c)
+				},
+			},
+			{
+				Name:  "squash",
+				Usage: "Replace versions up to a version with a single baseline migration",
+				Args:  true,
+				Flags: []cli.Flag{
+					&cli.Int64Flag{
+						Name:     "through",
+						Usage:    "Last version to squash into the baseline",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "keep",
+						Usage: "Keep the scratch database instead of dropping it",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if err := loadEnv(c); err != nil {
+						return err
+					}
+					dir := migrationDir()
+					through := c.Int64("through")
+					return withScratchDB(c, "squash", func(conn *sql.DB) error {
+						runner := internal.NewRunner(os.DirFS(dir), conn)
+						if err := runner.MigrateTo(c.Context, through); err != nil {
+							return err
+						}
+						stmts, err := runner.DumpStatements(c.Context)
+						if err != nil {
 							return err
 						}
+						path, err := internal.Squash(dir, through, stmts)
+						if err != nil {
+							return err
+						}
+						log.Info().
+							Str("path", path).
+							Msg("mitch: wrote baseline migration")
 						return nil
+					})
+				},
+			},
+			{
+				Name:  "export",
+				Usage: "Render pending versions as a single script for clickhouse-client",
+				Args:  true,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "from",
+						Usage: "Version the script starts after, or \"current\" for the database version",
+						Value: "current",
+					},
+					&cli.Int64Flag{
+						Name:  "to",
+						Usage: "Last version to include (defaults to the latest)",
+					},
+					&cli.BoolFlag{
+						Name:  "no-bookkeeping",
+						Usage: "Leave out the version table inserts",
+					},
+					&cli.StringFlag{
+						Name:  "out",
+						Usage: "File the script is written to, stdout when not set",
+						Value: "-",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if err := loadEnv(c); err != nil {
+						return err
+					}
+					conn, err := mitch.Connect(c.Args().First())
+					if err != nil {
+						return err
 					}
+					defer conn.Close()
 
-					// Forward mode
-					log.Debug().Msg("Running in forward mode...")
-					if err = runner.Migrate(context.Background()); err != nil {
-						log.Error().Err(err).Msg("runner.Migrate failed")
+					runner := internal.NewRunner(migrationFS(), conn, runnerOptions(c)...)
+					vers, err := exportVersions(c, runner)
+					if err != nil {
+						return err
+					}
+					script, err := runner.RenderScript(vers, !c.Bool("no-bookkeeping"))
+					if err != nil {
 						return err
 					}
+					return writeOutput(c.String("out"), script, "mitch: wrote migration script")
+				},
+			},
+			{
+				Name:  "mark-applied",
+				Usage: "Record pending versions as applied without running them",
+				Args:  true,
+				Flags: []cli.Flag{
+					&cli.Int64Flag{
+						Name:  "to",
+						Usage: "Last version to mark (defaults to the latest)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if err := loadEnv(c); err != nil {
+						return err
+					}
+					conn, err := mitch.Connect(c.Args().First())
+					if err != nil {
+						return err
+					}
+					defer conn.Close()
+
+					runner := internal.NewRunner(migrationFS(), conn, runnerOptions(c)...)
+					marked, err := runner.MarkApplied(c.Context, targetVersion(c))
+					if err != nil {
+						return err
+					}
+					log.Info().Msgf("mitch: marked %d versions as applied", len(marked))
+					return nil
+				},
+			},
+			{
+				Name:  "import",
+				Usage: "Record versions applied by another migration tool in the version table",
+				Args:  true,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "from",
+						Usage:    "Tool the history is imported from (goose, migrate or flyway)",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "table",
+						Usage: "History table of the tool (defaults to the tool's default table)",
+					},
+					&cli.StringFlag{
+						Name:  "convert",
+						Usage: "Directory of the tool's migration files to convert into the migration directory first",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if err := loadEnv(c); err != nil {
+						return err
+					}
+					tool, err := internal.LookupForeignTool(c.String("from"))
+					if err != nil {
+						return err
+					}
+					if src := c.String("convert"); src != "" {
+						files, err := tool.ReadFiles(os.DirFS(src))
+						if err != nil {
+							return err
+						}
+						written, err := internal.ConvertForeign(files, migrationDir())
+						if err != nil {
+							return err
+						}
+						log.Info().Msgf("mitch: converted %d %s migrations", len(written), tool.Name)
+					}
+
+					conn, err := mitch.Connect(c.Args().First())
+					if err != nil {
+						return err
+					}
+					defer conn.Close()
 
+					runner := internal.NewRunner(migrationFS(), conn, runnerOptions(c)...)
+					imported, err := runner.Import(c.Context, tool, c.String("table"))
+					if err != nil {
+						return err
+					}
+					log.Info().Msgf("mitch: imported %d versions from %s", len(imported), tool.Name)
 					return nil
 				},
 			},
+			{
+				Name:  "lint",
+				Usage: "Check migrations for risky ClickHouse patterns",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "disable",
+						Usage: "Comma-separated IDs of rules to skip",
+					},
+					&cli.StringSliceFlag{
+						Name:  "severity",
+						Usage: "Override the severity of a rule as rule=error|warning|note",
+					},
+					&cli.BoolFlag{
+						Name:  "cluster",
+						Usage: "Check migrations for use with ON CLUSTER DDL",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "Output format (text or sarif)",
+						Value: "text",
+					},
+					&cli.StringFlag{
+						Name:  "out",
+						Usage: "File the findings are written to, stdout when not set",
+						Value: "-",
+					},
+					&cli.BoolFlag{
+						Name:  "strict",
+						Usage: "Fail on warnings, not only on errors",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if err := loadEnv(c); err != nil {
+						return err
+					}
+					return lint(c)
+				},
+			},
+			{
+				Name: "run",
+				Args: true,
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "databases",
+						Usage: "Databases to apply the migration set to, instead of the connection database",
+					},
+					&cli.StringFlag{
+						Name:  "databases-from",
+						Usage: "Query whose first column lists the databases to apply the migration set to",
+					},
+					&cli.StringFlag{
+						Name:  "hosts",
+						Usage: "Comma-separated host:port list to apply the migration set to, each with its own version table",
+					},
+					&cli.StringFlag{
+						Name:  "hosts-from-cluster",
+						Usage: "Cluster in system.clusters whose shards and replicas the migration set is applied to",
+					},
+					&cli.IntFlag{
+						Name:  "concurrency",
+						Usage: "Number of hosts or databases migrated at the same time",
+						Value: internal.DefaultConcurrency,
+					},
+					&cli.BoolFlag{
+						Name:  "continue-on-error",
+						Usage: "Keep migrating remaining hosts or databases when one fails",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if err := loadEnv(c); err != nil {
+						return err
+					}
+
+					dbURL := c.Args().First()
+					if dbURL == "" {
+						log.Warn().Msg("DB URL argument not set, will try env vars...")
+					}
+					if isFanout(c) {
+						return runFanout(c, dbURL)
+					}
+
+					conn, err := mitch.Connect(dbURL)
+					if err != nil {
+						return err
+					}
+					defer conn.Close()
+
+					runner := internal.NewRunner(migrationFS(), conn, runnerOptions(c)...)
+					err = migrate(c.Context, c, runner)
+					if path := c.String("dump-schema"); err == nil && path != "" {
+						err = dumpSchema(c.Context, runner, path)
+					}
+					if rerr := writeReports(c, false, runner.Report()); rerr != nil {
+						return errors.Join(err, rerr)
+					}
+					return err
+				},
+			},
 		},
 	}
 
@@ -151,3 +626,172 @@ func main() {
 			Msg("failed to run command")
 	}
 }
+
+// runnerOptions collects Runner options from flags, falling back to env vars.
+// It must be called after the env file has been loaded.
+func runnerOptions(c *cli.Context) []internal.RunnerOption {
+	opts := []internal.RunnerOption{
+		internal.WithVersionTable(flagOrEnv(c, "version-table", mitch.EnvVersionTable)),
+		internal.WithVersionDB(flagOrEnv(c, "version-db", mitch.EnvVersionDB)),
+	}
+	if timeout := c.Duration("mutation-timeout"); timeout > 0 {
+		opts = append(opts, internal.WithMutationTimeout(timeout))
+	}
+	opts = append(opts, internal.WithMutationFailGrace(c.Duration("mutation-fail-grace")))
+	if settings, err := internal.ParseSettings(c.String("settings")); err == nil && len(settings) > 0 {
+		// validated by the flag's action
+		opts = append(opts, internal.WithQuerySettings(settings))
+	}
+	if timeout := c.Duration("statement-timeout"); timeout > 0 {
+		opts = append(opts, internal.WithStatementTimeout(timeout))
+	}
+	if attempts := c.Int("max-attempts"); attempts > 1 {
+		policy := internal.DefaultRetryPolicy()
+		policy.MaxAttempts = attempts
+		policy.Backoff = c.Duration("retry-backoff")
+		policy.MaxBackoff = c.Duration("retry-max-backoff")
+		if codes := c.IntSlice("retry-code"); len(codes) > 0 {
+			policy.Codes = make([]int32, len(codes))
+			for i, code := range codes {
+				policy.Codes[i] = int32(code)
+			}
+		}
+		opts = append(opts, internal.WithRetry(policy))
+	}
+	if c.Bool("preflight") {
+		opts = append(opts, internal.WithPreflight(internal.PreflightConfig{
+			MaxReplicationQueue: c.Uint64("max-replication-queue"),
+			MinFreeDiskRatio:    c.Float64("min-free-disk"),
+			Skip:                c.StringSlice("preflight-skip"),
+		}))
+	}
+	if c.Bool("force") {
+		opts = append(opts, internal.WithForceRollback())
+	}
+	if c.Bool("allow-empty-rollback") {
+		opts = append(opts, internal.WithEmptyRollback())
+	}
+	if isProtected(c) && !c.Bool("allow-destructive") {
+		opts = append(opts, internal.WithDestructiveGuard(confirmDestructive))
+	}
+	return opts
+}
+
+func flagOrEnv(c *cli.Context, flag, env string) string {
+	if v := c.String(flag); v != "" {
+		return v
+	}
+	return os.Getenv(env)
+}
+
+func loadEnv(c *cli.Context) error {
+	configPath := c.String("env")
+	log.Info().
+		Str("path", configPath).
+		Msg("loading env vars from file...")
+	if err := godotenv.Load(configPath); err != nil {
+		return err
+	}
+
+	if c.Bool("password-stdin") {
+		password, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read password from stdin: %w", err)
+		}
+		// takes precedence over a password set in the env file
+		mitch.SetPassword(strings.TrimRight(string(password), "\r\n"))
+	}
+	return nil
+}
+
+func migrationFS() fs.FS {
+	return os.DirFS(migrationDir())
+}
+
+func migrationDir() string {
+	dir := os.Getenv(mitch.EnvMigrationDir)
+	if dir == "" {
+		log.Warn().Msgf(
+			"Migration directory env `%s` not set, defaulting to `%s`",
+			mitch.EnvMigrationDir,
+			mitch.DefaultMigrationDir,
+		)
+		dir = mitch.DefaultMigrationDir
+	}
+	return dir
+}
+
+// migrate runs the runner forward, or in rollback mode when `--rollback` is set
+func migrate(ctx context.Context, c *cli.Context, runner *internal.Runner) error {
+	rollbackFile := c.String("rollback")
+	// Rollback mode
+	if rollbackFile != "" {
+		log.Debug().
+			Str("file", rollbackFile).
+			Msg("Running in rollback mode...")
+		if err := runner.Rollback(ctx, rollbackFile); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	// Forward mode
+	log.Debug().Msg("Running in forward mode...")
+	if err := runner.Migrate(ctx); err != nil {
+		log.Error().Err(err).Msg("runner.Migrate failed")
+		return err
+	}
+
+	return nil
+}
+
+// dumpSchema writes the schema of the runner database to path, or stdout for `-`
+func dumpSchema(ctx context.Context, runner *internal.Runner, path string) error {
+	schema, err := runner.DumpSchema(ctx)
+	if err != nil {
+		return err
+	}
+	return writeOutput(path, schema, "mitch: wrote schema dump")
+}
+
+// writeOutput writes content to path, or stdout for `-`, logging msg for files
+func writeOutput(path, content, msg string) error {
+	if path == "-" {
+		_, err := io.WriteString(os.Stdout, content)
+		return err
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	log.Info().
+		Str("path", path).
+		Msg(msg)
+	return nil
+}
+
+// targetVersion returns the `--to` version, or the latest when not set
+func targetVersion(c *cli.Context) int64 {
+	if to := c.Int64("to"); to > 0 {
+		return to
+	}
+	return math.MaxInt64
+}
+
+// exportVersions selects the versions for `export` from `--from` and `--to`
+func exportVersions(c *cli.Context, runner *internal.Runner) (internal.Migration, error) {
+	from := c.String("from")
+	if from == "current" {
+		return runner.Pending(c.Context, targetVersion(c))
+	}
+
+	fromID, err := strconv.ParseInt(from, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --from %q: expected a version or `current`", from)
+	}
+	found, err := runner.CollectMigration()
+	if err != nil {
+		return nil, err
+	}
+	return internal.VersionsBetween(found, fromID, targetVersion(c))
+}
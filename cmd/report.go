@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/arhyth/mitch/internal"
+	"github.com/urfave/cli/v2"
+)
+
+// writeReports writes the run report of a single target, or the reports of
+// every target of a fan-out run, in the format set by `--report` and as JUnit
+// XML when `--junit` is set
+func writeReports(c *cli.Context, fanout bool, reports ...*internal.Report) error {
+	if path := c.String("junit"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create JUnit report file: %w", err)
+		}
+		defer f.Close()
+		if err := internal.WriteJUnit(f, reports, c.Bool("junit-statements")); err != nil {
+			return err
+		}
+	}
+
+	format := c.String("report")
+	if format == "" {
+		return nil
+	}
+	if err := checkReportFormat(format); err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if path := c.String("report-file"); path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create report file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if !fanout && len(reports) == 1 {
+		return internal.WriteJSON(out, reports[0])
+	}
+	return internal.WriteJSON(out, reports)
+}
+
+// checkReportFormat fails on formats `writeReports` cannot write
+func checkReportFormat(format string) error {
+	switch format {
+	case "", "json":
+		return nil
+	default:
+		return fmt.Errorf("unknown report format: %s", format)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestCheckReportFormat(t *testing.T) {
+	for format, ok := range map[string]bool{
+		"":      true,
+		"json":  true,
+		"JSON":  false,
+		"text":  false,
+		"junit": false,
+	} {
+		err := checkReportFormat(format)
+		assert.Equal(t, ok, err == nil, format)
+	}
+}
+
+func TestWriteReports(t *testing.T) {
+	report := &internal.Report{
+		Direction:    "up",
+		StartVersion: 1,
+		EndVersion:   2,
+		Versions: []internal.VersionReport{{
+			ID:        3,
+			Source:    "003_comments_table.sql",
+			Direction: "up",
+			Statements: []internal.StatementReport{
+				{Index: 1, Class: "safe"},
+				{Index: 2, Class: "data-altering", Error: "Code: 60. DB::Exception: Table default.comments does not exist"},
+			},
+			Error:           "Code: 60. DB::Exception: Table default.comments does not exist",
+			FailedStatement: 2,
+		}},
+		Error: "failed to apply version 3",
+	}
+	newContext := func(tt *testing.T, format string) (*cli.Context, string) {
+		path := filepath.Join(tt.TempDir(), "report.json")
+		set := flag.NewFlagSet("mitch", flag.ContinueOnError)
+		set.String("report", format, "")
+		set.String("report-file", path, "")
+		set.String("junit", "", "")
+		set.Bool("junit-statements", false, "")
+		return cli.NewContext(cli.NewApp(), set, nil), path
+	}
+
+	t.Run("json", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		c, path := newContext(tt, "json")
+		reqrd.NoError(writeReports(c, false, report))
+
+		content, err := os.ReadFile(path)
+		reqrd.NoError(err)
+		var written internal.Report
+		reqrd.NoError(json.Unmarshal(content, &written))
+		reqrd.Equal("failed to apply version 3", written.Error)
+		reqrd.Len(written.Versions, 1)
+		reqrd.Equal(2, written.Versions[0].FailedStatement)
+		reqrd.Equal(report.Versions[0].Statements, written.Versions[0].Statements)
+	})
+
+	t.Run("fan-out", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		c, path := newContext(tt, "json")
+		reqrd.NoError(writeReports(c, true, report))
+
+		content, err := os.ReadFile(path)
+		reqrd.NoError(err)
+		var written []internal.Report
+		reqrd.NoError(json.Unmarshal(content, &written))
+		reqrd.Len(written, 1)
+		reqrd.Equal(3, int(written[0].Versions[0].ID))
+	})
+
+	t.Run("unknown format", func(tt *testing.T) {
+		c, _ := newContext(tt, "yaml")
+		assert.Error(tt, writeReports(c, false, report))
+	})
+}
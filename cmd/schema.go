@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/arhyth/mitch"
+	"github.com/arhyth/mitch/internal"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+// generateDiff writes a migration turning the live database, or a scratch
+// database the migrations were applied to, into the schema of `--to`
+func generateDiff(c *cli.Context) error {
+	schemaPath := c.String("to")
+	migrationDir := migrationDir()
+	dirFs := os.DirFS(migrationDir)
+
+	return withScratchDB(c, "desired", func(desiredConn *sql.DB) error {
+		f, err := os.Open(schemaPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := internal.LoadSchema(c.Context, desiredConn, f); err != nil {
+			return err
+		}
+		desired, err := internal.NewRunner(dirFs, desiredConn).Schema(c.Context)
+		if err != nil {
+			return err
+		}
+
+		currentSchema := func(conn *sql.DB, opts ...internal.RunnerOption) error {
+			current, err := internal.NewRunner(dirFs, conn, opts...).Schema(c.Context)
+			if err != nil {
+				return err
+			}
+			return writeDiff(c, dirFs, migrationDir, internal.GenerateMigration(current, desired))
+		}
+		if c.Bool("from-migrations") {
+			return withScratchDB(c, "current", func(conn *sql.DB) error {
+				if err := internal.NewRunner(dirFs, conn).Migrate(c.Context); err != nil {
+					return err
+				}
+				return currentSchema(conn)
+			})
+		}
+
+		conn, err := mitch.Connect(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return currentSchema(conn, runnerOptions(c)...)
+	})
+}
+
+func writeDiff(c *cli.Context, dirFs fs.FS, migrationDir string, gen *internal.GeneratedMigration) error {
+	for _, w := range gen.Warnings {
+		log.Warn().Msg(w)
+	}
+	if len(gen.Up) == 0 {
+		if len(gen.Warnings) > 0 {
+			return mitch.ErrUnsupportedChange
+		}
+		log.Info().Msg("mitch: schema is up to date, no migration generated")
+		return nil
+	}
+
+	content := gen.Render(fmt.Sprintf("Generated by mitch diff --to %s", c.String("to")))
+	if c.Bool("print") {
+		_, err := io.WriteString(os.Stdout, content)
+		return err
+	}
+
+	fname, err := internal.NextMigrationName(dirFs, c.String("name"))
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(migrationDir, fname)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		return fmt.Errorf("failed to write migration: %w", err)
+	}
+	log.Info().
+		Str("path", path).
+		Int("statements", len(gen.Up)).
+		Msg("mitch: generated migration")
+	return nil
+}
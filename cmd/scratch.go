@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/arhyth/mitch"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+// withScratchDB creates a temporary database on the server of the connection
+// settings, runs fn with a connection to it and drops it afterwards,
+// unless `--keep` is set
+func withScratchDB(c *cli.Context, purpose string, fn func(conn *sql.DB) error) error {
+	opts, err := mitch.ParseDBURL(c.Args().First())
+	if err != nil {
+		return err
+	}
+	conn, err := mitch.ConnectOptions(opts)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	dbName := fmt.Sprintf("mitch_%s_%d", purpose, time.Now().UnixNano())
+	help := newDBHelperFor(conn)
+	if err := help.CreateDatabase(dbName); err != nil {
+		return err
+	}
+	log.Info().
+		Str("database", dbName).
+		Msg("created scratch database")
+	defer func() {
+		if c.Bool("keep") {
+			log.Info().
+				Str("database", dbName).
+				Msg("keeping scratch database")
+			return
+		}
+		if err := help.DropDatabase(dbName); err != nil {
+			log.Error().
+				Err(err).
+				Str("database", dbName).
+				Msg("failed to drop scratch database")
+		}
+	}()
+
+	scratchOpts := *opts
+	scratchOpts.Auth.Database = dbName
+	scratch, err := mitch.ConnectOptions(&scratchOpts)
+	if err != nil {
+		return err
+	}
+	defer scratch.Close()
+
+	return fn(scratch)
+}
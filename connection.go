@@ -1,29 +1,108 @@
 package mitch
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
 	"strings"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 )
 
 func Connect(dbURL string) (db *sql.DB, err error) {
-	if strings.HasPrefix(dbURL, "clickhouse") {
-		db, err = sql.Open("clickhouse", dbURL)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		opts, err := GetDBOptions(dbURL)
-		if err != nil {
-			return nil, err
-		}
-		db = clickhouse.OpenDB(opts)
+	opts, err := ParseDBURL(dbURL)
+	if err != nil {
+		return nil, RedactError(err)
+	}
+	db, err = ConnectOptions(opts)
+	return db, RedactError(err)
+}
+
+// ParseDBURL returns connection options from either a `clickhouse://`,
+// `http://` or `https://` DSN, or a `host:port` address complemented by env vars
+func ParseDBURL(dbURL string) (*clickhouse.Options, error) {
+	if !isDSN(dbURL) {
+		return GetDBOptions(dbURL)
+	}
+
+	dsn, err := url.Parse(dbURL)
+	if err != nil {
+		return nil, RedactError(err)
+	}
+	if password, ok := dsn.User.Password(); ok {
+		RegisterSecret(password)
+	}
+	if password := dsn.Query().Get("password"); password != "" {
+		RegisterSecret(password)
+	}
+	if dsn.Scheme == "https" && !dsn.Query().Has("secure") {
+		// the driver refuses `https` without an explicit `secure` param
+		q := dsn.Query()
+		q.Set("secure", "true")
+		dsn.RawQuery = q.Encode()
+	}
+	opts, err := clickhouse.ParseDSN(dsn.String())
+	if err != nil {
+		return nil, RedactError(err)
 	}
+	if err := ApplyTransportEnv(opts); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
 
-	if err = db.Ping(); err != nil {
+// ConnectOptions opens and verifies a connection using prepared options.
+// Callers targeting several databases or hosts should copy the options
+// returned by `ParseDBURL` and adjust them per target.
+func ConnectOptions(opts *clickhouse.Options) (*sql.DB, error) {
+	db := clickhouse.OpenDB(opts)
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		if isTLSError(err) {
+			return nil, fmt.Errorf(
+				"%w with %s (check the server's secure port, CA bundle and server name): %w",
+				ErrTLSHandshake,
+				strings.Join(opts.Addr, ","),
+				err,
+			)
+		}
 		return nil, err
 	}
 
 	return db, nil
 }
+
+func isTLSError(err error) bool {
+	var (
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostErr      x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &recordErr),
+		errors.As(err, &alertErr),
+		errors.As(err, &verifyErr),
+		errors.As(err, &authorityErr),
+		errors.As(err, &hostErr),
+		errors.As(err, &invalidErr):
+		return true
+	}
+	// the driver does not always wrap dial errors
+	msg := err.Error()
+	return strings.Contains(msg, "tls: ") || strings.Contains(msg, "x509: ")
+}
+
+func isDSN(dbURL string) bool {
+	for _, scheme := range []string{"clickhouse", "http://", "https://"} {
+		if strings.HasPrefix(dbURL, scheme) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,8 +1,15 @@
 package mitch
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
 	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/rs/zerolog/log"
@@ -15,16 +22,61 @@ var (
 	EnvDatabaseName     = "CLICKHOUSE_DB"
 	EnvDatabaseUser     = "CLICKHOUSE_USER"
 	EnvDatabasePassword = "CLICKHOUSE_PASSWORD"
+	EnvPasswordFile     = "CLICKHOUSE_PASSWORD_FILE"
+	EnvCredentialCmd    = "CLICKHOUSE_CREDENTIAL_COMMAND"
+	EnvProtocol         = "CLICKHOUSE_PROTOCOL"
+	EnvCompression      = "CLICKHOUSE_COMPRESSION"
+	EnvCompressionLevel = "CLICKHOUSE_COMPRESSION_LEVEL"
+	EnvConnOpenStrategy = "CLICKHOUSE_CONN_OPEN_STRATEGY"
+	EnvDialTimeout      = "CLICKHOUSE_DIAL_TIMEOUT"
+	EnvReadTimeout      = "CLICKHOUSE_READ_TIMEOUT"
+	EnvMaxExecutionTime = "CLICKHOUSE_MAX_EXECUTION_TIME"
+	EnvHTTPHeaders      = "CLICKHOUSE_HTTP_HEADERS"
+	EnvHTTPPath         = "CLICKHOUSE_HTTP_PATH"
+	EnvTLS              = "CLICKHOUSE_TLS"
+	EnvTLSCAFile        = "CLICKHOUSE_TLS_CA_FILE"
+	EnvTLSCertFile      = "CLICKHOUSE_TLS_CERT_FILE"
+	EnvTLSKeyFile       = "CLICKHOUSE_TLS_KEY_FILE"
+	EnvTLSServerName    = "CLICKHOUSE_TLS_SERVER_NAME"
+	EnvTLSSkipVerify    = "CLICKHOUSE_TLS_INSECURE_SKIP_VERIFY"
+	EnvVersionTable     = "CLICKHOUSE_VERSION_TABLE"
+	EnvVersionDB        = "CLICKHOUSE_VERSION_DB"
+	EnvFanoutHosts      = "CLICKHOUSE_FANOUT_HOSTS"
+	EnvFanoutCluster    = "CLICKHOUSE_FANOUT_CLUSTER"
+	EnvLintDisable      = "CLICKHOUSE_LINT_DISABLE"
+	EnvProtected        = "CLICKHOUSE_PROTECTED"
 
 	DefaultMigrationDir = "migrations"
 	DefaultPort         = "9000"
+	DefaultHTTPPort     = "8123"
+	DefaultSecurePort   = "9440"
+	DefaultHTTPSPort    = "8443"
 	VersionTable        = "mitch_db_version"
 	// these defaults are of Clickhouse and not ours
 	DefaultUser     = "default"
 	DefaultPassword = ""
 )
 
+var compressionMethods = map[string]clickhouse.CompressionMethod{
+	"none":    clickhouse.CompressionNone,
+	"lz4":     clickhouse.CompressionLZ4,
+	"zstd":    clickhouse.CompressionZSTD,
+	"gzip":    clickhouse.CompressionGZIP,
+	"deflate": clickhouse.CompressionDeflate,
+	"br":      clickhouse.CompressionBrotli,
+}
+
 func GetDBOptions(dbURL string) (*clickhouse.Options, error) {
+	protocol, err := GetProtocol()
+	if err != nil {
+		return nil, err
+	}
+	tlsConf, err := GetTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []string
 	if dbURL == "" {
 		dbHost := os.Getenv(EnvDatabaseHost)
 		if dbHost == "" {
@@ -32,33 +84,349 @@ func GetDBOptions(dbURL string) (*clickhouse.Options, error) {
 		}
 		port := os.Getenv(EnvDatabasePort)
 		if port == "" {
-			port = DefaultPort
+			port = DefaultPortFor(protocol, tlsConf != nil)
 		}
-		dbURL = fmt.Sprintf("%s:%s", dbHost, port)
+		addrs = splitHosts(dbHost, port)
+	} else {
+		addrs = splitHosts(dbURL, DefaultPortFor(protocol, tlsConf != nil))
 	}
 
 	dbName := os.Getenv(EnvDatabaseName)
 	if dbName == "" {
 		return nil, ErrUnsetDBName
 	}
-	dbUser := os.Getenv(EnvDatabaseUser)
+	dbUser, dbPasswd, err := GetCredentials()
+	if err != nil {
+		return nil, err
+	}
 	if dbUser == "" {
 		log.Warn().Msgf("%s not set; using user `default`", EnvDatabaseUser)
 		dbUser = DefaultUser
 	}
-	dbPasswd := os.Getenv(EnvDatabasePassword)
 	if dbPasswd == "" {
 		if dbUser != DefaultUser {
 			return nil, ErrUnsetDBPassword
 		}
 		dbPasswd = DefaultPassword
 	}
-	return &clickhouse.Options{
-		Addr: []string{dbURL},
+	opts := &clickhouse.Options{
+		Protocol: protocol,
+		Addr:     addrs,
 		Auth: clickhouse.Auth{
 			Database: dbName,
 			Username: dbUser,
 			Password: dbPasswd,
 		},
-	}, nil
+	}
+	if err := applyTransportEnv(opts, tlsConf); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// passwordOverride is set by `SetPassword`, eg. from stdin
+var passwordOverride string
+
+// SetPassword sets the password `GetCredentials` returns over any set in the
+// environment. Unlike `CLICKHOUSE_PASSWORD`, it is not passed on to child
+// processes such as the credential command.
+func SetPassword(p string) {
+	RegisterSecret(p)
+	passwordOverride = p
+}
+
+// GetCredentials returns the user and password from, in order of precedence,
+// `CLICKHOUSE_USER` and `SetPassword` or `CLICKHOUSE_PASSWORD`, the file in
+// `CLICKHOUSE_PASSWORD_FILE` and the output of `CLICKHOUSE_CREDENTIAL_COMMAND`.
+// Like a git credential helper, the command prints `username=<user>` and
+// `password=<password>` lines.
+// The password is registered for redaction.
+func GetCredentials() (user, password string, err error) {
+	user = os.Getenv(EnvDatabaseUser)
+	password = passwordOverride
+	if password == "" {
+		password = os.Getenv(EnvDatabasePassword)
+	}
+
+	if path := os.Getenv(EnvPasswordFile); password == "" && path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read password file: %w", err)
+		}
+		password = strings.TrimRight(string(content), "\r\n")
+	}
+
+	if command := os.Getenv(EnvCredentialCmd); password == "" && command != "" {
+		helperUser, helperPassword, err := runCredentialCommand(command)
+		if err != nil {
+			return "", "", err
+		}
+		if user == "" {
+			user = helperUser
+		}
+		password = helperPassword
+	}
+
+	RegisterSecret(password)
+	return user, password, nil
+}
+
+func runCredentialCommand(command string) (user, password string, err error) {
+	cmd := exec.Command("sh", "-c", command)
+	stderr := new(strings.Builder)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	// the output is only logged once the password it may hold is registered
+	defer logCredentialStderr(stderr.String())
+	if err != nil {
+		// the command line itself may embed a secret, only report the failure
+		return "", "", fmt.Errorf("%w: %w", ErrCredentialCommand, err)
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		k, v, ok := strings.Cut(strings.TrimRight(line, "\r"), "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "username":
+			user = v
+		case "password":
+			password = v
+		}
+	}
+	RegisterSecret(password)
+	return user, password, nil
+}
+
+// logCredentialStderr logs what the credential command wrote to stderr,
+// redacted as it may echo the secret
+func logCredentialStderr(stderr string) {
+	for _, line := range strings.Split(strings.TrimSpace(stderr), "\n") {
+		if line == "" {
+			continue
+		}
+		log.Warn().Str("stderr", Redact(line)).Msg("credential command")
+	}
+}
+
+// GetProtocol returns the ClickHouse interface set in `CLICKHOUSE_PROTOCOL`,
+// either `native` (default) or `http`
+func GetProtocol() (clickhouse.Protocol, error) {
+	switch p := strings.ToLower(os.Getenv(EnvProtocol)); p {
+	case "", "native", "tcp":
+		return clickhouse.Native, nil
+	case "http":
+		return clickhouse.HTTP, nil
+	default:
+		return clickhouse.Native, fmt.Errorf("%w: %s", ErrInvalidProtocol, p)
+	}
+}
+
+// GetTLSConfig returns the TLS config set by `CLICKHOUSE_TLS*` env vars, or nil
+// when TLS is not enabled. Setting a CA bundle or client certificate enables TLS.
+func GetTLSConfig() (*tls.Config, error) {
+	enabled, err := envBool(EnvTLS)
+	if err != nil {
+		return nil, err
+	}
+	skipVerify, err := envBool(EnvTLSSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	caFile := os.Getenv(EnvTLSCAFile)
+	certFile := os.Getenv(EnvTLSCertFile)
+	keyFile := os.Getenv(EnvTLSKeyFile)
+	if !enabled && caFile == "" && certFile == "" {
+		return nil, nil
+	}
+
+	conf := &tls.Config{
+		ServerName:         os.Getenv(EnvTLSServerName),
+		InsecureSkipVerify: skipVerify,
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCABundle, caFile)
+		}
+		conf.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, ErrIncompleteClientCert
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// splitHosts splits a comma-separated host list, adding port to hosts without one
+func splitHosts(hosts, port string) []string {
+	var addrs []string
+	for _, host := range strings.Split(hosts, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, port)
+		}
+		addrs = append(addrs, host)
+	}
+	return addrs
+}
+
+// DefaultPortFor returns the default ClickHouse port of protocol, with or
+// without TLS
+func DefaultPortFor(protocol clickhouse.Protocol, secure bool) string {
+	switch {
+	case protocol == clickhouse.HTTP && secure:
+		return DefaultHTTPSPort
+	case protocol == clickhouse.HTTP:
+		return DefaultHTTPPort
+	case secure:
+		return DefaultSecurePort
+	default:
+		return DefaultPort
+	}
+}
+
+func envDuration(key string) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0, nil
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return d, nil
+}
+
+func envBool(key string) (bool, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", key, err)
+	}
+	return b, nil
+}
+
+// IsProtected reports whether the environment is marked as protected, where
+// destructive statements need confirmation
+func IsProtected() (bool, error) {
+	return envBool(EnvProtected)
+}
+
+// ApplyTransportEnv sets TLS, compression, connection tuning and HTTP specific
+// options from env vars. It applies to options parsed from a DSN as well, since
+// a DSN has no way to express certificates or custom HTTP headers.
+func ApplyTransportEnv(opts *clickhouse.Options) error {
+	tlsConf, err := GetTLSConfig()
+	if err != nil {
+		return err
+	}
+	return applyTransportEnv(opts, tlsConf)
+}
+
+// applyTransportEnv is `ApplyTransportEnv` with the TLS config already built
+// from env vars, certificates are only read once
+func applyTransportEnv(opts *clickhouse.Options, tlsConf *tls.Config) error {
+	if tlsConf != nil {
+		if opts.TLS != nil {
+			// keep `skip_verify` set in the DSN
+			tlsConf.InsecureSkipVerify = tlsConf.InsecureSkipVerify || opts.TLS.InsecureSkipVerify
+		}
+		opts.TLS = tlsConf
+	}
+
+	if name := strings.ToLower(os.Getenv(EnvCompression)); name != "" {
+		method, ok := compressionMethods[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrInvalidCompression, name)
+		}
+		// the same default level the driver uses for DSN `compress` param
+		opts.Compression = &clickhouse.Compression{Method: method, Level: 3}
+	}
+	if level := os.Getenv(EnvCompressionLevel); level != "" {
+		n, err := strconv.Atoi(level)
+		if err != nil {
+			return fmt.Errorf("%s: %w", EnvCompressionLevel, err)
+		}
+		if opts.Compression == nil {
+			// a level alone doesn't enable compression, same as the driver DSN
+			opts.Compression = &clickhouse.Compression{Method: clickhouse.CompressionNone}
+		}
+		opts.Compression.Level = n
+	}
+
+	switch strategy := strings.ToLower(os.Getenv(EnvConnOpenStrategy)); strategy {
+	case "":
+	case "in_order":
+		opts.ConnOpenStrategy = clickhouse.ConnOpenInOrder
+	case "round_robin":
+		opts.ConnOpenStrategy = clickhouse.ConnOpenRoundRobin
+	case "random":
+		opts.ConnOpenStrategy = clickhouse.ConnOpenRandom
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidConnStrategy, strategy)
+	}
+
+	dialTimeout, err := envDuration(EnvDialTimeout)
+	if err != nil {
+		return err
+	}
+	if dialTimeout > 0 {
+		opts.DialTimeout = dialTimeout
+	}
+	readTimeout, err := envDuration(EnvReadTimeout)
+	if err != nil {
+		return err
+	}
+	if readTimeout > 0 {
+		opts.ReadTimeout = readTimeout
+	}
+	maxExecTime, err := envDuration(EnvMaxExecutionTime)
+	if err != nil {
+		return err
+	}
+	if maxExecTime > 0 {
+		if opts.Settings == nil {
+			opts.Settings = make(clickhouse.Settings)
+		}
+		opts.Settings["max_execution_time"] = int(maxExecTime.Seconds())
+	}
+
+	if headers := os.Getenv(EnvHTTPHeaders); headers != "" {
+		if opts.HttpHeaders == nil {
+			opts.HttpHeaders = make(map[string]string)
+		}
+		for _, kv := range strings.Split(headers, ",") {
+			k, v, ok := strings.Cut(kv, "=")
+			k = strings.TrimSpace(k)
+			if !ok || k == "" {
+				return fmt.Errorf("%w: %s", ErrInvalidHTTPHeader, kv)
+			}
+			opts.HttpHeaders[k] = strings.TrimSpace(v)
+		}
+	}
+	if path := os.Getenv(EnvHTTPPath); path != "" {
+		opts.HttpUrlPath = path
+	}
+	return nil
 }
@@ -3,11 +3,42 @@ package mitch
 import "errors"
 
 var (
-	ErrUnsetDBURL         = errors.New("no database url or host set")
-	ErrUnsetDBName        = errors.New("database name not set")
-	ErrUnsetDBPassword    = errors.New("database password not set")
-	ErrFileVersionPrefix  = errors.New("migration filename has no version prefix")
-	ErrVersionZero        = errors.New("migration version must be greater than zero")
-	ErrVersionDiscrepancy = errors.New("migration applied changed to a different version number")
-	ErrMultiStatementLine = errors.New("line has multiple SQL statements")
+	ErrUnsetDBURL           = errors.New("no database url or host set")
+	ErrUnsetDBName          = errors.New("database name not set")
+	ErrUnsetDBPassword      = errors.New("database password not set")
+	ErrCredentialCommand    = errors.New("credential command failed")
+	ErrInvalidProtocol      = errors.New("unknown protocol, expected native or http")
+	ErrInvalidCompression   = errors.New("unknown compression method")
+	ErrInvalidConnStrategy  = errors.New("unknown connection open strategy, expected in_order, round_robin or random")
+	ErrInvalidHTTPHeader    = errors.New("HTTP header must be formatted as name=value")
+	ErrInvalidCABundle      = errors.New("no certificates found in CA bundle")
+	ErrIncompleteClientCert = errors.New("client certificate and key must be set together")
+	ErrTLSHandshake         = errors.New("TLS handshake failed")
+	ErrFileVersionPrefix    = errors.New("migration filename has no version prefix")
+	ErrVersionZero          = errors.New("migration version must be greater than zero")
+	ErrVersionDiscrepancy   = errors.New("migration applied changed to a different version number")
+	ErrMultiStatementLine   = errors.New("line has multiple SQL statements")
+	ErrTargetSkipped        = errors.New("skipped after an earlier target failed")
+	ErrTargetsDiverged      = errors.New("targets are on different versions")
+	ErrIrreversible         = errors.New("migration is not reversible")
+	ErrSchemaDrift          = errors.New("database schema drifted from migrations")
+	ErrUnsupportedChange    = errors.New("schema change cannot be applied in place")
+	ErrBaselineGap          = errors.New("database predates baseline")
+	ErrMissingVersionFile   = errors.New("applied version missing in filesystem migrations")
+	ErrNothingToSquash      = errors.New("nothing to squash")
+	ErrUnknownTool          = errors.New("unknown migration tool")
+	ErrDirtyHistory         = errors.New("migration history is dirty")
+	ErrForeignVersion       = errors.New("version cannot be converted to an integer version")
+	ErrUnknownLintRule      = errors.New("unknown lint rule")
+	ErrInvalidSeverity      = errors.New("unknown lint severity")
+	ErrLintFindings         = errors.New("migrations have lint findings")
+	ErrDestructiveChange    = errors.New("destructive statements refused")
+	ErrInvalidAnnotation    = errors.New("invalid migration annotation")
+	ErrMutationFailed       = errors.New("mutation failed")
+	ErrMutationTimeout      = errors.New("mutation did not finish in time")
+	ErrStatementTimeout     = errors.New("statement did not finish in time")
+	ErrPreflightFailed      = errors.New("preflight checks failed")
+	ErrNotExportable        = errors.New("version cannot be exported as a script")
+	ErrServerVersion        = errors.New("ClickHouse server version not supported by migration")
+	ErrClusterNotFound      = errors.New("cluster not found in system.clusters")
 )
@@ -0,0 +1,45 @@
+package internal
+
+import "strings"
+
+// annotationPrefix starts a directive line in a migration file, eg.
+// `-- +mitch baseline` or `-- +mitch requires-clickhouse: >=24.3`
+const annotationPrefix = "-- +mitch "
+
+// Annotation is a `-- +mitch <key>[: <value>]` directive in a migration file
+type Annotation struct {
+	Key   string
+	Value string
+}
+
+type Annotations []Annotation
+
+// Get returns the value of the last annotation with key
+func (as Annotations) Get(key string) (string, bool) {
+	for i := len(as) - 1; i >= 0; i-- {
+		if as[i].Key == key {
+			return as[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// Has reports whether an annotation with key is present
+func (as Annotations) Has(key string) bool {
+	_, ok := as.Get(key)
+	return ok
+}
+
+// ParseAnnotation parses a single directive line
+func ParseAnnotation(line string) (Annotation, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, annotationPrefix) {
+		return Annotation{}, false
+	}
+	directive := strings.TrimSpace(strings.TrimPrefix(line, annotationPrefix))
+	key, value, _ := strings.Cut(directive, ":")
+	return Annotation{
+		Key:   strings.ToLower(strings.TrimSpace(key)),
+		Value: strings.TrimSpace(value),
+	}, true
+}
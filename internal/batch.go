@@ -0,0 +1,316 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/arhyth/mitch"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// BatchPartition runs a batched version once per active partition of a table
+	BatchPartition = "partition"
+	// BatchRange runs a batched version once per key range of a table
+	BatchRange = "range"
+)
+
+// BatchSpec is the strategy of a batched data migration, from a
+// `-- +mitch batch: partition table=<table>` or
+// `-- +mitch batch: range table=<table> key=<column> size=<rows>` directive
+type BatchSpec struct {
+	Strategy string
+	// Table is the `[database.]table` the batches are taken from
+	Table string
+	// Key and Size are the integer column and width of range batches
+	Key  string
+	Size int64
+}
+
+// Batch is a slice of a batched migration, the data of its statement
+// templates
+type Batch struct {
+	// Partition is the partition ID of partition batches, eg. 202401, to
+	// compare with `_partition_id`
+	Partition string
+	// Start and End bound the key of range batches, Start inclusive and End
+	// exclusive
+	Start, End int64
+}
+
+// ID identifies the batch in the checkpoints of its version
+func (b Batch) ID() string {
+	if b.Partition != "" {
+		return b.Partition
+	}
+	return fmt.Sprintf("%d-%d", b.Start, b.End)
+}
+
+// ParseBatchSpec parses the value of a `batch` directive
+func ParseBatchSpec(s string) (BatchSpec, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return BatchSpec{}, fmt.Errorf("%w: batch: missing strategy", mitch.ErrInvalidAnnotation)
+	}
+	spec := BatchSpec{Strategy: fields[0]}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || value == "" {
+			return BatchSpec{}, fmt.Errorf("%w: batch: expected key=value, got %q", mitch.ErrInvalidAnnotation, field)
+		}
+		switch key {
+		case "table":
+			spec.Table = value
+		case "key":
+			spec.Key = value
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size <= 0 {
+				return BatchSpec{}, fmt.Errorf("%w: batch: size must be a positive integer, got %q", mitch.ErrInvalidAnnotation, value)
+			}
+			spec.Size = size
+		default:
+			return BatchSpec{}, fmt.Errorf("%w: batch: unknown option %q", mitch.ErrInvalidAnnotation, key)
+		}
+	}
+
+	if spec.Table == "" {
+		return BatchSpec{}, fmt.Errorf("%w: batch: missing table", mitch.ErrInvalidAnnotation)
+	}
+	switch spec.Strategy {
+	case BatchPartition:
+	case BatchRange:
+		if spec.Key == "" || spec.Size == 0 {
+			return BatchSpec{}, fmt.Errorf("%w: batch: range requires key and size", mitch.ErrInvalidAnnotation)
+		}
+	default:
+		return BatchSpec{}, fmt.Errorf(
+			"%w: batch: unknown strategy %q, expected %s or %s",
+			mitch.ErrInvalidAnnotation, spec.Strategy, BatchPartition, BatchRange,
+		)
+	}
+	return spec, nil
+}
+
+// BatchSpec returns the batch strategy of the version, ok is false for
+// versions that are not batched
+func (v Version) BatchSpec() (spec BatchSpec, ok bool, err error) {
+	value, ok := v.Annotations.Get("batch")
+	if !ok {
+		return BatchSpec{}, false, nil
+	}
+	spec, err = ParseBatchSpec(value)
+	if err != nil {
+		return BatchSpec{}, true, fmt.Errorf("%s: %w", v.Source, err)
+	}
+	return spec, true, nil
+}
+
+// RangeBatches splits the keys from first to last in batches of size. Batches
+// are aligned to multiples of size so they stay the same as the table grows.
+func RangeBatches(first, last, size int64) []Batch {
+	start := first - first%size
+	if first%size < 0 {
+		start -= size
+	}
+	var batches []Batch
+	for ; start <= last; start += size {
+		batches = append(batches, Batch{Start: start, End: start + size})
+	}
+	return batches
+}
+
+// batchTableSuffix names the batch table after the version table
+const batchTableSuffix = "_batches"
+
+// BatchTable returns the table checkpointing the batches of batched versions
+func (rr *Runner) BatchTable() string {
+	return rr.VersionTable() + batchTableSuffix
+}
+
+func batchTableDDL(table string) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    version_id Int64,
+    content_hash String,
+    batch String,
+    created_at DateTime default now()
+)
+ENGINE = MergeTree()
+ORDER BY (version_id, batch);`, table)
+}
+
+// runBatches runs the statements of section once per batch of spec, rendered
+// with the batch. Batches completed by an earlier run of the same version
+// content are skipped, the others are checkpointed as they complete.
+func (rr *Runner) runBatches(ctx context.Context, tx *runTx, ver Version, spec BatchSpec, section *SQL, vr *VersionReport) error {
+	tmpls := make([]*template.Template, len(section.Statements))
+	for idx, stmt := range section.Statements {
+		tmpl, err := template.New(strconv.Itoa(idx + 1)).Option("missingkey=error").Parse(stmt)
+		if err != nil {
+			return fmt.Errorf("%w: %s statement %d: %v", mitch.ErrInvalidAnnotation, ver.Source, idx+1, err)
+		}
+		tmpls[idx] = tmpl
+	}
+	throttle, err := annotatedDuration("throttle", 0, nil, ver.Annotations)
+	if err != nil {
+		return err
+	}
+
+	if _, err := rr.db.ExecContext(ctx, batchTableDDL(rr.BatchTable())); err != nil {
+		return fmt.Errorf("failed to create batch table: %w", err)
+	}
+	batches, err := rr.batches(ctx, spec)
+	if err != nil {
+		return err
+	}
+	done, err := rr.doneBatches(ctx, ver)
+	if err != nil {
+		return err
+	}
+	if len(done) > 0 {
+		log.Info().
+			Str("source", ver.Source).
+			Int("done", len(done)).
+			Int("total", len(batches)).
+			Msg("mitch: resuming batched version")
+	}
+
+	var ran int
+	for n, batch := range batches {
+		if done[batch.ID()] {
+			continue
+		}
+		if ran > 0 && throttle > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(throttle):
+			}
+		}
+		ran++
+		log.Info().
+			Str("source", ver.Source).
+			Str("batch", batch.ID()).
+			Int("batch_number", n+1).
+			Int("total", len(batches)).
+			Msg("mitch: running batch")
+
+		rendered := &SQL{
+			Statements:  make([]string, len(tmpls)),
+			Lines:       section.Lines,
+			Annotations: section.Annotations,
+		}
+		for idx, tmpl := range tmpls {
+			var buf strings.Builder
+			if err := tmpl.Execute(&buf, batch); err != nil {
+				return fmt.Errorf("%w: %s statement %d: %v", mitch.ErrInvalidAnnotation, ver.Source, idx+1, err)
+			}
+			rendered.Statements[idx] = buf.String()
+		}
+
+		first := len(vr.Statements)
+		err := rr.runStatements(ctx, tx, ver, rendered, vr)
+		for i := first; i < len(vr.Statements); i++ {
+			vr.Statements[i].Batch = batch.ID()
+		}
+		if err != nil {
+			return fmt.Errorf("batch %s: %w", batch.ID(), err)
+		}
+
+		op := fmt.Sprintf("checkpoint batch %s of version %d", batch.ID(), ver.ID)
+		recorded := func() (bool, error) {
+			q := `SELECT count() FROM %s WHERE version_id = $1 AND content_hash = $2 AND batch = $3;`
+			return rr.anyRows(ctx, fmt.Sprintf(q, rr.BatchTable()), ver.ID, ver.ContentHash, batch.ID())
+		}
+		err = rr.insertOnce(ctx, op, tx.renew, recorded, func() error {
+			q := `INSERT INTO %s (version_id, content_hash, batch) VALUES ($1, $2, $3);`
+			_, err := tx.ExecContext(ctx, fmt.Sprintf(q, rr.BatchTable()), ver.ID, ver.ContentHash, batch.ID())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to checkpoint batch %s: %w", batch.ID(), err)
+		}
+	}
+	return nil
+}
+
+// batches lists the batches of spec, partitions from `system.parts` or key
+// ranges from the bounds of the key
+func (rr *Runner) batches(ctx context.Context, spec BatchSpec) ([]Batch, error) {
+	if spec.Strategy == BatchRange {
+		q := fmt.Sprintf(
+			"SELECT count(), toInt64(min(%[1]s)), toInt64(max(%[1]s)) FROM %[2]s;",
+			spec.Key, spec.Table,
+		)
+		var (
+			count       uint64
+			first, last int64
+		)
+		if err := rr.db.QueryRowContext(ctx, q).Scan(&count, &first, &last); err != nil {
+			return nil, fmt.Errorf("failed to query key range of %s: %w", spec.Table, err)
+		}
+		if count == 0 {
+			return nil, nil
+		}
+		return RangeBatches(first, last, spec.Size), nil
+	}
+
+	database, table, ok := strings.Cut(spec.Table, ".")
+	if !ok {
+		database, table = rr.GetDBName(), spec.Table
+	}
+	q := `
+		SELECT DISTINCT partition_id
+		FROM system.parts
+		WHERE database = $1 AND table = $2 AND active
+		ORDER BY partition_id;
+	`
+	rows, err := rr.db.QueryContext(ctx, q, unquoteIdent(database), unquoteIdent(table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query partitions of %s: %w", spec.Table, err)
+	}
+	defer rows.Close()
+
+	var batches []Batch
+	for rows.Next() {
+		var b Batch
+		if err := rows.Scan(&b.Partition); err != nil {
+			return nil, fmt.Errorf("failed to scan partitions: %w", err)
+		}
+		batches = append(batches, b)
+	}
+	return batches, rows.Err()
+}
+
+// doneBatches returns the IDs of the checkpointed batches of ver. Checkpoints
+// of other content of the version, eg. before an edit, do not count.
+func (rr *Runner) doneBatches(ctx context.Context, ver Version) (map[string]bool, error) {
+	q := `SELECT DISTINCT batch FROM %s WHERE version_id = $1 AND content_hash = $2;`
+	rows, err := rr.db.QueryContext(ctx, fmt.Sprintf(q, rr.BatchTable()), ver.ID, ver.ContentHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query batch checkpoints: %w", err)
+	}
+	defer rows.Close()
+
+	done := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan batch checkpoints: %w", err)
+		}
+		done[id] = true
+	}
+	return done, rows.Err()
+}
+
+// clearBatches deletes the checkpoints of ver once it is applied, so that
+// applying it again after a rollback runs every batch
+func (rr *Runner) clearBatches(ctx context.Context, ver Version) error {
+	q := `ALTER TABLE %s DELETE WHERE version_id = $1 SETTINGS mutations_sync = 2;`
+	_, err := rr.db.ExecContext(ctx, fmt.Sprintf(q, rr.BatchTable()), ver.ID)
+	return err
+}
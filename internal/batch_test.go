@@ -0,0 +1,85 @@
+package internal_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/arhyth/mitch"
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBatchSpec(t *testing.T) {
+	t.Run("strategies", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		spec, err := internal.ParseBatchSpec("partition table=analytics.events")
+		reqrd.NoError(err)
+		reqrd.Equal(internal.BatchSpec{Strategy: internal.BatchPartition, Table: "analytics.events"}, spec)
+
+		spec, err = internal.ParseBatchSpec("range table=events key=id size=1000000")
+		reqrd.NoError(err)
+		reqrd.Equal(internal.BatchSpec{Strategy: internal.BatchRange, Table: "events", Key: "id", Size: 1000000}, spec)
+	})
+
+	t.Run("invalid", func(tt *testing.T) {
+		as := assert.New(tt)
+		for _, s := range []string{
+			"",
+			"partition",
+			"hash table=events",
+			"range table=events key=id",
+			"range table=events key=id size=0",
+			"partition table=events where=1",
+		} {
+			_, err := internal.ParseBatchSpec(s)
+			as.True(errors.Is(err, mitch.ErrInvalidAnnotation), s)
+		}
+	})
+
+	t.Run("directive", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		content := "-- +mitch batch: range table=events key=id size=1000\n" +
+			"-- +mitch throttle: 2s\n" +
+			"\n" +
+			"INSERT INTO events_v2 SELECT * FROM events WHERE id >= {{.Start}} AND id < {{.End}};\n" +
+			"\n" +
+			"/* rollback\n" +
+			"TRUNCATE TABLE IF EXISTS events_v2;\n" +
+			"*/"
+		ver, err := internal.ParseMigration(strings.NewReader(content))
+		reqrd.NoError(err)
+		spec, ok, err := ver.BatchSpec()
+		reqrd.NoError(err)
+		reqrd.True(ok)
+		reqrd.Equal("events", spec.Table)
+		reqrd.Len(ver.Up.Statements, 1)
+
+		_, ok, err = internal.Version{}.BatchSpec()
+		reqrd.NoError(err)
+		reqrd.False(ok)
+	})
+}
+
+func TestRangeBatches(t *testing.T) {
+	as := assert.New(t)
+	as.Equal([]internal.Batch{
+		{Start: 1000, End: 2000},
+		{Start: 2000, End: 3000},
+		{Start: 3000, End: 4000},
+	}, internal.RangeBatches(1234, 3000, 1000))
+	as.Equal([]internal.Batch{{Start: -10, End: 0}, {Start: 0, End: 10}}, internal.RangeBatches(-3, 9, 10))
+	as.Equal([]internal.Batch{{Start: 0, End: 100}}, internal.RangeBatches(42, 42, 100))
+	as.Equal("1000-2000", internal.Batch{Start: 1000, End: 2000}.ID())
+	as.Equal("202401", internal.Batch{Partition: "202401"}.ID())
+}
+
+func TestExcludedTables(t *testing.T) {
+	as := assert.New(t)
+	runner := internal.NewRunner(nil, nil, internal.WithVersionTable("versions"))
+	as.Equal([]string{"versions", "versions_batches"}, runner.ExcludedTables())
+
+	runner = internal.NewRunner(nil, nil, internal.WithVersionTable("versions"), internal.WithVersionDB("mitch"))
+	as.Equal("mitch.versions_batches", runner.BatchTable())
+}
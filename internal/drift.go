@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/arhyth/mitch"
+	"github.com/rs/zerolog/log"
+)
+
+// DriftReport lists the differences of a live database from the schema its
+// migration history describes
+type DriftReport struct {
+	Database string         `json:"database"`
+	Drifted  bool           `json:"drifted"`
+	Changes  []SchemaChange `json:"changes"`
+}
+
+// DetectDrift compares the schema of live with the schema of expected, a runner
+// whose database has had the full migration set applied. Changes describe what
+// was done to live on top of its migrations, eg. a hand-run ALTER.
+func DetectDrift(ctx context.Context, expected, live *Runner) (*DriftReport, error) {
+	want, err := expected.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	got, err := live.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := want.Diff(got)
+	if changes == nil {
+		changes = []SchemaChange{}
+	}
+	return &DriftReport{
+		Database: live.GetDBName(),
+		Drifted:  len(changes) > 0,
+		Changes:  changes,
+	}, nil
+}
+
+// Write writes the report to w as JSON or as one line per change
+func (dr *DriftReport) Write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(dr)
+	case "", "text":
+		if !dr.Drifted {
+			_, err := fmt.Fprintf(w, "mitch: no drift detected in database %s\n", dr.Database)
+			return err
+		}
+		for _, ch := range dr.Changes {
+			if _, err := fmt.Fprintln(w, ch.String()); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown drift report format: %s", format)
+	}
+}
+
+// Err returns ErrSchemaDrift when the database drifted
+func (dr *DriftReport) Err() error {
+	if !dr.Drifted {
+		return nil
+	}
+	log.Error().
+		Str("database", dr.Database).
+		Int("changes", len(dr.Changes)).
+		Msg("mitch: schema drift detected")
+	return mitch.ErrSchemaDrift
+}
@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	rgxUUIDClause = regexp.MustCompile(`\s+UUID\s+'[0-9a-fA-F-]{36}'`)
+	rgxUUID       = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	// replica name, the 2nd argument of Replicated*MergeTree engines
+	rgxReplicaName = regexp.MustCompile(`(Replicated\w*MergeTree\('[^']*',\s*)'[^']*'`)
+)
+
+// dumpKinds orders objects in a dump so that it can be replayed:
+// dictionaries and views are created after the tables they may read from
+var dumpKinds = []struct {
+	kind    string
+	engines string
+}{
+	{"TABLE", "engine NOT IN ('View', 'MaterializedView', 'LiveView', 'WindowView', 'Dictionary')"},
+	{"DICTIONARY", "engine = 'Dictionary'"},
+	{"VIEW", "engine = 'View'"},
+	{"MATERIALIZED VIEW", "engine IN ('MaterializedView', 'LiveView', 'WindowView')"},
+}
+
+// DumpSchema returns `SHOW CREATE` output for every user-defined function and
+// every table, dictionary, view and materialized view of dbName, ordered by kind
+// and name. Volatile parts are normalised so dumps of the same schema compare
+// equal across servers and databases: the database qualifier, UUIDs and replica
+// names. Tables named in exclude, eg. the version table, are left out.
+func DumpSchema(ctx context.Context, db *sql.DB, dbName string, exclude ...string) (string, error) {
+	stmts, err := DumpStatements(ctx, db, dbName, exclude...)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(strings.Builder)
+	buf.WriteString("-- Schema dump generated by mitch. Do not edit.\n\n")
+	writeStatements(buf, stmts)
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// DumpStatements returns the normalised statements of `DumpSchema`
+func DumpStatements(ctx context.Context, db *sql.DB, dbName string, exclude ...string) ([]string, error) {
+	skip := make(map[string]bool, len(exclude))
+	for _, name := range exclude {
+		skip[name] = true
+	}
+
+	var stmts []string
+	functionsQ := `
+		SELECT create_query
+		FROM system.functions
+		WHERE origin = 'SQLUserDefined'
+		ORDER BY name;
+	`
+	fns, err := queryStrings(ctx, db, functionsQ)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list functions: %w", err)
+	}
+	stmts = append(stmts, fns...)
+
+	for _, k := range dumpKinds {
+		q := fmt.Sprintf(`
+			SELECT name
+			FROM system.tables
+			WHERE database = $1 AND NOT is_temporary AND %s
+			ORDER BY name;
+		`, k.engines)
+		names, err := queryStrings(ctx, db, q, dbName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		for _, name := range names {
+			if skip[name] || strings.HasPrefix(name, ".inner") {
+				continue
+			}
+			kind := "TABLE"
+			if k.kind == "DICTIONARY" {
+				kind = k.kind
+			}
+			var create string
+			q := fmt.Sprintf("SHOW CREATE %s %s.%s", kind, quoteIdent(dbName), quoteIdent(name))
+			if err := db.QueryRowContext(ctx, q).Scan(&create); err != nil {
+				return nil, fmt.Errorf("failed to show create %s: %w", name, err)
+			}
+			stmts = append(stmts, create)
+		}
+	}
+
+	for i, st := range stmts {
+		stmts[i] = NormaliseDDL(st, dbName)
+	}
+	return stmts, nil
+}
+
+// writeStatements writes statements terminated by `;` and separated by
+// blank lines, as expected by `SplitSQLStatements`
+func writeStatements(buf *strings.Builder, stmts []string) {
+	for _, st := range stmts {
+		buf.WriteString(st)
+		buf.WriteString(";\n\n")
+	}
+}
+
+// NormaliseDDL strips parts of a `SHOW CREATE` statement that differ between
+// servers and databases holding the same schema
+func NormaliseDDL(stmt, dbName string) string {
+	stmt = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(stmt), ";"))
+	qualifier := regexp.QuoteMeta(dbName)
+	rgxQualifier := regexp.MustCompile("`" + qualifier + "`\\.|\\b" + qualifier + "\\.")
+	stmt = rgxQualifier.ReplaceAllString(stmt, "")
+	stmt = rgxUUIDClause.ReplaceAllString(stmt, "")
+	stmt = rgxUUID.ReplaceAllString(stmt, "{uuid}")
+	stmt = rgxReplicaName.ReplaceAllString(stmt, "$1'{replica}'")
+	return stmt
+}
+
+func queryStrings(ctx context.Context, db *sql.DB, query string, args ...any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// DumpSchema dumps the schema of the database, without mitch's own tables
+func (rr *Runner) DumpSchema(ctx context.Context) (string, error) {
+	return DumpSchema(ctx, rr.db, rr.GetDBName(), rr.ExcludedTables()...)
+}
+
+// DumpStatements returns the statements of `DumpSchema`
+func (rr *Runner) DumpStatements(ctx context.Context) ([]string, error) {
+	return DumpStatements(ctx, rr.db, rr.GetDBName(), rr.ExcludedTables()...)
+}
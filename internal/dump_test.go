@@ -0,0 +1,28 @@
+package internal_test
+
+import (
+	"testing"
+
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormaliseDDL(t *testing.T) {
+	as := assert.New(t)
+
+	stmt := "CREATE TABLE tenant_a.events UUID '4f1c8e2a-9a7b-4c7e-8f3d-2b1a0c9d8e7f'\n" +
+		"(\n    `id` UInt64\n)\n" +
+		"ENGINE = ReplicatedMergeTree('/clickhouse/tables/4f1c8e2a-9a7b-4c7e-8f3d-2b1a0c9d8e7f/01', 'ch-1')\n" +
+		"ORDER BY id\nSETTINGS index_granularity = 8192"
+	expected := "CREATE TABLE events\n" +
+		"(\n    `id` UInt64\n)\n" +
+		"ENGINE = ReplicatedMergeTree('/clickhouse/tables/{uuid}/01', '{replica}')\n" +
+		"ORDER BY id\nSETTINGS index_granularity = 8192"
+	as.Equal(expected, internal.NormaliseDDL(stmt, "tenant_a"))
+
+	view := "CREATE MATERIALIZED VIEW `tenant_a`.posts_view TO tenant_a.posts_agg AS SELECT * FROM other_tenant_a.posts"
+	as.Equal(
+		"CREATE MATERIALIZED VIEW posts_view TO posts_agg AS SELECT * FROM other_tenant_a.posts",
+		internal.NormaliseDDL(view, "tenant_a"),
+	)
+}
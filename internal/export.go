@@ -0,0 +1,241 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/arhyth/mitch"
+	"github.com/rs/zerolog/log"
+)
+
+// Pending returns the versions `MigrateTo` would apply for target, without
+// applying them. A database without a version table has every version pending.
+func (rr *Runner) Pending(ctx context.Context, target int64) (Migration, error) {
+	foundVersions, err := rr.CollectMigration()
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := rr.versionTableExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var dbVersions []Version
+	if exists {
+		if dbVersions, err = rr.ListDBVersions(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var current int64
+	if len(dbVersions) > 0 {
+		current = dbVersions[0].ID
+	}
+	unapplied, hasMissing := FindUnappliedVersions(dbVersions, foundVersions)
+	if hasMissing {
+		log.Warn().Msg("Migration have missing versions")
+	}
+	return pendingUpTo(unapplied, current, target)
+}
+
+// VersionsBetween returns the versions of ms after from, up to and including
+// to, as they would be pending for a database at version from
+func VersionsBetween(ms Migration, from, to int64) (Migration, error) {
+	var after Migration
+	for _, ver := range ms {
+		if ver.ID > from {
+			after = append(after, ver)
+		}
+	}
+	return pendingUpTo(after, from, to)
+}
+
+// MarkApplied records the versions `MigrateTo` would apply for target in the
+// version table without running their statements, eg. after they were run by
+// hand from an exported script. It returns the recorded versions.
+func (rr *Runner) MarkApplied(ctx context.Context, target int64) (Migration, error) {
+	pending, err := rr.Pending(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	if err := rr.MustVersionTable(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, ver := range pending {
+		tx, err := rr.db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		if err := rr.InsertVersion(ctx, tx, ver); err != nil {
+			_ = tx.Rollback()
+			return nil, fmt.Errorf("failed to insert new version: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		log.Info().
+			Int64("version", ver.ID).
+			Str("source", ver.Source).
+			Msg("mitch: marked version as applied")
+	}
+	return pending, nil
+}
+
+func (rr *Runner) versionTableExists(ctx context.Context) (bool, error) {
+	var exists uint8
+	q := fmt.Sprintf("EXISTS TABLE %s;", rr.VersionTable())
+	if err := rr.db.QueryRowContext(ctx, q).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check version table: %w", err)
+	}
+	return exists == 1, nil
+}
+
+// ScriptOptions configure `RenderScript`
+type ScriptOptions struct {
+	VersionTable string
+	// VersionDB is the database of the version table when it is not the
+	// migrated one, see `WithVersionDB`. Bookkeeping creates it if needed.
+	VersionDB string
+	// Bookkeeping creates the version table if needed and records every
+	// version right after its statements
+	Bookkeeping bool
+	// Settings apply to statements without a settings directive of their own,
+	// see `WithQuerySettings`
+	Settings map[string]string
+}
+
+// RenderScript renders the up sections of vers as a single script runnable with
+// `clickhouse-client --multiquery`. Settings directives become `SETTINGS`
+// clauses. Versions whose statements depend on the server, ie. with
+// alternative up sections, must be resolved first, see `Runner.RenderScript`.
+// Batched versions cannot be exported.
+func RenderScript(vers Migration, opts ScriptOptions) (string, error) {
+	buf := new(strings.Builder)
+	buf.WriteString("-- Migration script generated by mitch.\n")
+	if len(vers) == 0 {
+		buf.WriteString("-- No pending versions.\n")
+		return buf.String(), nil
+	}
+	fmt.Fprintf(buf, "-- Versions %d to %d, run with: clickhouse-client --multiquery < script.sql\n", vers[0].ID, vers[len(vers)-1].ID)
+	if !opts.Bookkeeping {
+		fmt.Fprintf(buf, "-- Versions are not recorded in %s, run `mitch mark-applied` afterwards.\n", opts.VersionTable)
+	}
+	buf.WriteString("\n")
+	if opts.Bookkeeping && opts.VersionDB != "" {
+		fmt.Fprintf(buf, "CREATE DATABASE IF NOT EXISTS %s;\n\n", opts.VersionDB)
+	}
+	if opts.Bookkeeping {
+		buf.WriteString(versionTableDDL(opts.VersionTable))
+		buf.WriteString("\n\n")
+	}
+
+	for _, ver := range vers {
+		if len(ver.Alternatives) > 0 {
+			return "", fmt.Errorf("%w: %s has up sections for other ClickHouse versions", mitch.ErrNotExportable, ver.Source)
+		}
+		if _, batched, _ := ver.BatchSpec(); batched {
+			return "", fmt.Errorf("%w: %s is a batched data migration and can only be applied by mitch", mitch.ErrNotExportable, ver.Source)
+		}
+		fmt.Fprintf(buf, "-- Version %d: %s\n", ver.ID, ver.Source)
+		for idx, stmt := range ver.Up.Statements {
+			settings, err := annotatedSettings(opts.Settings, ver.Up.AnnotationsAt(idx), ver.Annotations)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", ver.Source, err)
+			}
+			if len(settings) > 0 {
+				var ok bool
+				if stmt, ok = withSettingsClause(stmt, settings); !ok {
+					return "", fmt.Errorf(
+						"%w: %s statement %d does not take a SETTINGS clause",
+						mitch.ErrNotExportable, ver.Source, idx+1,
+					)
+				}
+			}
+			buf.WriteString(terminate(stmt))
+			buf.WriteString("\n\n")
+		}
+		if opts.Bookkeeping {
+			fmt.Fprintf(
+				buf,
+				"INSERT INTO %s (version_id, source, content_hash) VALUES (%d, %s, %s);\n\n",
+				opts.VersionTable, ver.ID, quoteString(ver.Source), quoteString(ver.ContentHash),
+			)
+		}
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// RenderScript renders vers with `RenderScript` for the connected server: it
+// checks their `requires-clickhouse` directives and picks their up sections
+// as `Run` does
+func (rr *Runner) RenderScript(vers Migration, bookkeeping bool) (string, error) {
+	resolved := make(Migration, len(vers))
+	for i, ver := range vers {
+		if err := rr.checkServer(ver); err != nil {
+			return "", err
+		}
+		section, err := rr.section(ver, Up)
+		if err != nil {
+			return "", err
+		}
+		ver.Up = section
+		ver.Alternatives = nil
+		resolved[i] = ver
+	}
+	return RenderScript(resolved, ScriptOptions{
+		VersionTable: rr.VersionTable(),
+		VersionDB:    rr.versionDB,
+		Bookkeeping:  bookkeeping,
+		Settings:     rr.querySettings,
+	})
+}
+
+var rgxTrailingSettings = regexp.MustCompile(`(?is)\bSETTINGS\s+[^()]*$`)
+
+// withSettingsClause appends settings to the trailing SETTINGS clause of stmt.
+// ok is false for statements where a trailing clause means something else,
+// eg. the table settings of CREATE TABLE or the data of INSERT ... VALUES.
+func withSettingsClause(stmt string, settings clickhouse.Settings) (_ string, ok bool) {
+	norm := normaliseStatement(stmt)
+	switch {
+	case strings.HasPrefix(norm, "ALTER "),
+		strings.HasPrefix(norm, "OPTIMIZE "),
+		strings.HasPrefix(norm, "SELECT "),
+		strings.HasPrefix(norm, "WITH "):
+	case strings.HasPrefix(norm, "INSERT ") && strings.Contains(norm, " SELECT ") &&
+		!strings.Contains(norm, " VALUES") && !strings.Contains(norm, " FORMAT "):
+	default:
+		return stmt, false
+	}
+
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		switch v := settings[k].(type) {
+		case int:
+			pairs[i] = fmt.Sprintf("%s = %d", k, v)
+		default:
+			pairs[i] = fmt.Sprintf("%s = %s", k, quoteString(fmt.Sprint(v)))
+		}
+	}
+
+	stmt = strings.TrimSuffix(strings.TrimSpace(stmt), ";")
+	if rgxTrailingSettings.MatchString(stmt) {
+		return stmt + ", " + strings.Join(pairs, ", "), true
+	}
+	return stmt + " SETTINGS " + strings.Join(pairs, ", "), true
+}
+
+func quoteString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+}
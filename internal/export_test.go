@@ -0,0 +1,132 @@
+package internal_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/arhyth/mitch"
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionsBetween(t *testing.T) {
+	ms := internal.Migration{
+		{ID: 1, Source: "001_baseline.sql", Annotations: internal.Annotations{{Key: "baseline"}}},
+		{ID: 2, Source: "002_users.sql"},
+		{ID: 3, Source: "003_posts.sql"},
+	}
+
+	t.Run("range", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		vers, err := internal.VersionsBetween(ms, 1, 2)
+		reqrd.NoError(err)
+		reqrd.Len(vers, 1)
+		assert.Equal(tt, int64(2), vers[0].ID)
+	})
+
+	t.Run("baseline gap", func(tt *testing.T) {
+		_, err := internal.VersionsBetween(internal.Migration{ms[0]}, 0, 3)
+		assert.NoError(tt, err)
+		_, err = internal.VersionsBetween(internal.Migration{{ID: 5, Annotations: ms[0].Annotations}}, 3, 5)
+		assert.True(tt, errors.Is(err, mitch.ErrBaselineGap))
+	})
+}
+
+func TestRenderScript(t *testing.T) {
+	vers := internal.Migration{
+		{
+			ID:          2,
+			Source:      "002_add_new_field_norollback.sql",
+			ContentHash: "abc",
+			Up:          &internal.SQL{Statements: []string{anotherUpSQL}},
+		},
+	}
+
+	t.Run("bookkeeping", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		as := assert.New(tt)
+		script, err := internal.RenderScript(vers, internal.ScriptOptions{VersionTable: "db.mitch_db_version", Bookkeeping: true})
+		reqrd.NoError(err)
+		as.Contains(script, "CREATE TABLE IF NOT EXISTS db.mitch_db_version")
+		as.Contains(script, "-- Version 2: 002_add_new_field_norollback.sql\n"+anotherUpSQL+"\n\n")
+		as.Contains(script, "INSERT INTO db.mitch_db_version (version_id, source, content_hash) VALUES (2, '002_add_new_field_norollback.sql', 'abc');")
+	})
+
+	t.Run("version db", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		as := assert.New(tt)
+		runner := internal.NewRunner(nil, nil, internal.WithVersionDB("mitch_meta"))
+		script, err := runner.RenderScript(vers, true)
+		reqrd.NoError(err)
+		createDB := strings.Index(script, "CREATE DATABASE IF NOT EXISTS mitch_meta;")
+		createTable := strings.Index(script, "CREATE TABLE IF NOT EXISTS mitch_meta.mitch_db_version")
+		reqrd.NotEqual(-1, createDB)
+		as.Less(createDB, createTable)
+
+		script, err = runner.RenderScript(vers, false)
+		reqrd.NoError(err)
+		as.NotContains(script, "CREATE DATABASE")
+	})
+
+	t.Run("no bookkeeping", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		as := assert.New(tt)
+		script, err := internal.RenderScript(vers, internal.ScriptOptions{VersionTable: "db.mitch_db_version"})
+		reqrd.NoError(err)
+		as.Contains(script, anotherUpSQL)
+		as.NotContains(script, "INSERT INTO")
+		as.Contains(script, "mitch mark-applied")
+	})
+
+	t.Run("settings", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		as := assert.New(tt)
+		vers := internal.Migration{{
+			ID:          3,
+			Source:      "003_backfill.sql",
+			Annotations: internal.Annotations{{Key: "settings", Value: "max_execution_time=3600"}},
+			Up: &internal.SQL{
+				Statements: []string{
+					"INSERT INTO b SELECT * FROM a;",
+					"ALTER TABLE b UPDATE c = 1 WHERE 1 SETTINGS mutations_sync = 2;",
+				},
+				Annotations: []internal.Annotations{nil, {{Key: "settings", Value: "max_execution_time=60, alter_sync=2"}}},
+			},
+		}}
+		script, err := internal.RenderScript(vers, internal.ScriptOptions{
+			VersionTable: "db.mitch_db_version",
+			Settings:     map[string]string{"max_memory_usage": "10G"},
+		})
+		reqrd.NoError(err)
+		as.Contains(script, "INSERT INTO b SELECT * FROM a SETTINGS max_execution_time = 3600, max_memory_usage = '10G';")
+		as.Contains(script, "ALTER TABLE b UPDATE c = 1 WHERE 1 SETTINGS mutations_sync = 2, alter_sync = 2, max_execution_time = 60, max_memory_usage = '10G';")
+
+		vers[0].Up.Statements = append(vers[0].Up.Statements, "CREATE TABLE c (x UInt8) ENGINE = MergeTree ORDER BY x")
+		_, err = internal.RenderScript(vers, internal.ScriptOptions{VersionTable: "db.mitch_db_version"})
+		as.True(errors.Is(err, mitch.ErrNotExportable))
+	})
+
+	t.Run("alternatives", func(tt *testing.T) {
+		vers := internal.Migration{{
+			ID:           4,
+			Source:       "004_json.sql",
+			Up:           &internal.SQL{Statements: []string{anotherUpSQL}},
+			Alternatives: []internal.Alternative{{Constraint: "<24.8", Up: &internal.SQL{}}},
+		}}
+		_, err := internal.RenderScript(vers, internal.ScriptOptions{VersionTable: "db.mitch_db_version"})
+		assert.True(tt, errors.Is(err, mitch.ErrNotExportable))
+	})
+
+	t.Run("batched", func(tt *testing.T) {
+		vers := internal.Migration{{
+			ID:          5,
+			Source:      "005_backfill.sql",
+			Up:          &internal.SQL{Statements: []string{"INSERT INTO t SELECT * FROM s WHERE _partition_id = '{{.Partition}}';"}},
+			Annotations: internal.Annotations{{Key: "batch", Value: "partition table=s"}},
+		}}
+		_, err := internal.RenderScript(vers, internal.ScriptOptions{VersionTable: "db.mitch_db_version"})
+		assert.True(tt, errors.Is(err, mitch.ErrNotExportable))
+	})
+}
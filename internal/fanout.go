@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/arhyth/mitch"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultConcurrency is the number of targets migrated at the same time
+// when not configured otherwise
+const DefaultConcurrency = 4
+
+// FanoutConfig controls how a migration set is applied to many targets
+type FanoutConfig struct {
+	// Concurrency bounds the number of targets migrated at the same time
+	Concurrency int
+	// ContinueOnError keeps migrating the remaining targets when one fails.
+	// Otherwise, the first failure skips targets that have not started yet.
+	ContinueOnError bool
+}
+
+// TargetFunc applies a migration set to a single target
+// and returns the resulting version of the target
+type TargetFunc func(ctx context.Context, target string) (int64, error)
+
+// TargetResult is the outcome of applying a migration set to a single target
+type TargetResult struct {
+	Target   string
+	Version  int64
+	Duration time.Duration
+	Err      error
+}
+
+// Fanout runs fn for every target with bounded concurrency.
+// Results are returned in the same order as targets. The returned error
+// joins the errors of all failed targets.
+func Fanout(ctx context.Context, targets []string, cfg FanoutConfig, fn TargetFunc) ([]TargetResult, error) {
+	results := make([]TargetResult, len(targets))
+	for i, t := range targets {
+		results[i] = TargetResult{Target: t, Err: mitch.ErrTargetSkipped}
+	}
+
+	limit := cfg.Concurrency
+	if limit < 1 {
+		limit = DefaultConcurrency
+	}
+
+	// stopped keeps targets from starting after a failure, targets already
+	// running finish their migration with the unaffected ctx
+	var stopped atomic.Bool
+	var mu sync.Mutex
+	errgp := new(errgroup.Group)
+	errgp.SetLimit(limit)
+	for idx, target := range targets {
+		errgp.Go(func() error {
+			if ctx.Err() != nil || stopped.Load() {
+				return nil
+			}
+
+			start := time.Now()
+			ver, err := fn(ctx, target)
+			res := TargetResult{
+				Target:   target,
+				Version:  ver,
+				Duration: time.Since(start),
+				Err:      err,
+			}
+			mu.Lock()
+			results[idx] = res
+			mu.Unlock()
+
+			if err != nil {
+				log.Error().
+					Err(err).
+					Str("target", target).
+					Msg("mitch: target migration failed")
+				if !cfg.ContinueOnError {
+					stopped.Store(true)
+				}
+			}
+			return nil
+		})
+	}
+	_ = errgp.Wait()
+
+	var errs []error
+	for _, res := range results {
+		if res.Err != nil && !errors.Is(res.Err, mitch.ErrTargetSkipped) {
+			errs = append(errs, fmt.Errorf("%s: %w", res.Target, res.Err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// LogSummary logs the outcome of every target followed by a tally
+func LogSummary(results []TargetResult) {
+	var ok, failed, skipped int
+	for _, res := range results {
+		switch {
+		case res.Err == nil:
+			ok++
+			log.Info().
+				Str("target", res.Target).
+				Int64("version", res.Version).
+				Dur("duration", res.Duration).
+				Msg("mitch: target ok")
+		case errors.Is(res.Err, mitch.ErrTargetSkipped):
+			skipped++
+			log.Warn().
+				Str("target", res.Target).
+				Msg("mitch: target skipped")
+		default:
+			failed++
+			log.Error().
+				Str("target", res.Target).
+				Err(res.Err).
+				Dur("duration", res.Duration).
+				Msg("mitch: target failed")
+		}
+	}
+
+	log.Info().Msgf("mitch: %d targets: %d ok, %d failed, %d skipped\n", len(results), ok, failed, skipped)
+}
+
+// ListDatabases returns the first column of every row returned by query,
+// eg. `SELECT name FROM system.databases WHERE name LIKE 'tenant_%'`
+func ListDatabases(ctx context.Context, db *sql.DB, query string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan list databases result: %w", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// CheckConverged returns ErrTargetsDiverged when the successful targets are not
+// all on the same version, eg. a shard that has an extra migration file applied
+func CheckConverged(results []TargetResult) error {
+	versions := make(map[int64][]string)
+	for _, res := range results {
+		if res.Err == nil {
+			versions[res.Version] = append(versions[res.Version], res.Target)
+		}
+	}
+	if len(versions) < 2 {
+		return nil
+	}
+
+	for ver, targets := range versions {
+		log.Warn().
+			Int64("version", ver).
+			Strs("targets", targets).
+			Msg("mitch: targets diverged")
+	}
+	return mitch.ErrTargetsDiverged
+}
+
+// ListClusterHosts returns the `host:port` native addresses of every shard
+// replica of cluster as listed in `system.clusters`
+func ListClusterHosts(ctx context.Context, db *sql.DB, cluster string) ([]string, error) {
+	q := `
+		SELECT host_name, port
+		FROM system.clusters
+		WHERE cluster = $1
+		ORDER BY shard_num, replica_num;
+	`
+	rows, err := db.QueryContext(ctx, q, cluster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cluster hosts: %w", err)
+	}
+	defer rows.Close()
+
+	var hosts []string
+	for rows.Next() {
+		var (
+			host string
+			port uint16
+		)
+		if err := rows.Scan(&host, &port); err != nil {
+			return nil, fmt.Errorf("failed to scan cluster hosts result: %w", err)
+		}
+		hosts = append(hosts, fmt.Sprintf("%s:%d", host, port))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("%w: %s", mitch.ErrClusterNotFound, cluster)
+	}
+	return hosts, nil
+}
@@ -0,0 +1,94 @@
+package internal_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/arhyth/mitch"
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFanout(t *testing.T) {
+	errBroken := errors.New("broken")
+	targets := []string{"tenant_a", "tenant_b", "tenant_c"}
+	fn := func(ctx context.Context, target string) (int64, error) {
+		if target == "tenant_b" {
+			return 0, errBroken
+		}
+		return 9, nil
+	}
+
+	t.Run("fail fast", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		as := assert.New(tt)
+		cfg := internal.FanoutConfig{Concurrency: 1}
+		results, err := internal.Fanout(context.Background(), targets, cfg, fn)
+		reqrd.ErrorIs(err, errBroken)
+		reqrd.Len(results, 3)
+		as.NoError(results[0].Err)
+		as.Equal(int64(9), results[0].Version)
+		as.ErrorIs(results[1].Err, errBroken)
+		as.ErrorIs(results[2].Err, mitch.ErrTargetSkipped)
+	})
+
+	t.Run("fail fast concurrent", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		as := assert.New(tt)
+		started, failed := make(chan struct{}), make(chan struct{})
+		fn := func(ctx context.Context, target string) (int64, error) {
+			switch target {
+			case "tenant_a":
+				// still running when tenant_b fails
+				close(started)
+				<-failed
+				time.Sleep(50 * time.Millisecond)
+				return 9, ctx.Err()
+			case "tenant_b":
+				<-started
+				close(failed)
+				return 0, errBroken
+			default:
+				return 9, nil
+			}
+		}
+		cfg := internal.FanoutConfig{Concurrency: 2}
+		results, err := internal.Fanout(context.Background(), targets, cfg, fn)
+		reqrd.ErrorIs(err, errBroken)
+		reqrd.Len(results, 3)
+		as.NoError(results[0].Err)
+		as.Equal(int64(9), results[0].Version)
+		as.ErrorIs(results[1].Err, errBroken)
+		as.ErrorIs(results[2].Err, mitch.ErrTargetSkipped)
+	})
+
+	t.Run("continue on error", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		as := assert.New(tt)
+		cfg := internal.FanoutConfig{Concurrency: 1, ContinueOnError: true}
+		results, err := internal.Fanout(context.Background(), targets, cfg, fn)
+		reqrd.ErrorIs(err, errBroken)
+		reqrd.Len(results, 3)
+		as.NoError(results[0].Err)
+		as.ErrorIs(results[1].Err, errBroken)
+		as.NoError(results[2].Err)
+		as.Equal(int64(9), results[2].Version)
+	})
+}
+
+func TestCheckConverged(t *testing.T) {
+	as := assert.New(t)
+
+	converged := []internal.TargetResult{
+		{Target: "ch-1:9000/default", Version: 9},
+		{Target: "ch-2:9000/default", Version: 9},
+		{Target: "ch-3:9000/default", Err: errors.New("connection refused")},
+	}
+	as.NoError(internal.CheckConverged(converged))
+
+	diverged := append(converged, internal.TargetResult{Target: "ch-4:9000/default", Version: 8})
+	as.ErrorIs(internal.CheckConverged(diverged), mitch.ErrTargetsDiverged)
+}
@@ -0,0 +1,297 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var rgxCreateFunction = regexp.MustCompile(`(?im)^\s*CREATE\s+(OR\s+REPLACE\s+)?FUNCTION\s`)
+
+// GeneratedMigration holds statements turning one schema into another
+type GeneratedMigration struct {
+	Up, Down []string
+	// Warnings lists changes ClickHouse cannot apply in place, eg. a new
+	// ORDER BY, which are left for the author to handle
+	Warnings []string
+}
+
+// step is an up statement paired with the statement reverting it
+type step struct {
+	up, down string
+}
+
+// LoadSchema executes the statements of a desired-state schema file, eg. one
+// written by `DumpSchema`, against db. User-defined functions are global rather
+// than per database and are skipped.
+func LoadSchema(ctx context.Context, db *sql.DB, schema io.Reader) error {
+	ver, err := ParseMigration(schema)
+	if err != nil {
+		return err
+	}
+	for _, st := range ver.Up.Statements {
+		if strings.TrimSpace(st) == "" || rgxCreateFunction.MatchString(st) {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, st); err != nil {
+			return fmt.Errorf("failed to load schema: %w", err)
+		}
+	}
+	return nil
+}
+
+// GenerateMigration plans the ALTER, CREATE and DROP statements that turn
+// current into desired and their inverse for the rollback section
+func GenerateMigration(current, desired *Schema) *GeneratedMigration {
+	// statements are grouped so that dependencies hold in both directions:
+	// views are dropped before and created after the tables they read from
+	var dropViews, createTables, alters, dropTables, createViews []step
+	gen := &GeneratedMigration{}
+
+	for _, ch := range current.Diff(desired) {
+		from := current.Tables[ch.Table]
+		to := desired.Tables[ch.Table]
+		name := quoteIdent(ch.Table)
+
+		switch ch.Kind {
+		case "table":
+			if ch.Change == "added" {
+				st := step{up: to.CreateQuery, down: dropStatement(to)}
+				if to.IsView() {
+					createViews = append(createViews, st)
+				} else {
+					createTables = append(createTables, st)
+				}
+				continue
+			}
+			st := step{up: dropStatement(from), down: from.CreateQuery}
+			if from.IsView() {
+				dropViews = append(dropViews, st)
+			} else {
+				dropTables = append(dropTables, st)
+			}
+
+		case "definition":
+			if to.Engine == "View" && from.Engine == "View" {
+				alters = append(alters, step{
+					up:   strings.Replace(to.CreateQuery, "CREATE VIEW", "CREATE OR REPLACE VIEW", 1),
+					down: strings.Replace(from.CreateQuery, "CREATE VIEW", "CREATE OR REPLACE VIEW", 1),
+				})
+				continue
+			}
+			dropViews = append(dropViews, step{up: dropStatement(from), down: from.CreateQuery})
+			createViews = append(createViews, step{up: to.CreateQuery, down: dropStatement(to)})
+			gen.Warnings = append(gen.Warnings, fmt.Sprintf(
+				"%s is recreated to change its definition, data of a materialized view without a TO table is lost", ch.Table,
+			))
+
+		case "column":
+			col := quoteIdent(ch.Name)
+			switch ch.Change {
+			case "added":
+				alters = append(alters, step{
+					up:   fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s;", name, col, ch.To),
+					down: fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s;", name, col),
+				})
+			case "removed":
+				alters = append(alters, step{
+					up:   fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s;", name, col),
+					down: fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s;", name, col, ch.From),
+				})
+			default:
+				alters = append(alters, step{
+					up:   modifyColumn(name, col, ch.From, ch.To),
+					down: modifyColumn(name, col, ch.To, ch.From),
+				})
+			}
+
+		case "index":
+			idx := quoteIdent(ch.Name)
+			add := func(def string) string {
+				return fmt.Sprintf("ALTER TABLE %s ADD INDEX IF NOT EXISTS %s %s;", name, idx, def)
+			}
+			drop := fmt.Sprintf("ALTER TABLE %s DROP INDEX IF EXISTS %s;", name, idx)
+			switch ch.Change {
+			case "added":
+				alters = append(alters, step{up: add(ch.To), down: drop})
+			case "removed":
+				alters = append(alters, step{up: drop, down: add(ch.From)})
+			default:
+				alters = append(alters, step{up: drop, down: add(ch.From)}, step{up: add(ch.To), down: drop})
+			}
+
+		case "ttl":
+			modify := func(ttl string) string {
+				if ttl == "" {
+					return fmt.Sprintf("ALTER TABLE %s REMOVE TTL;", name)
+				}
+				return fmt.Sprintf("ALTER TABLE %s MODIFY TTL %s;", name, ttl)
+			}
+			alters = append(alters, step{up: modify(ch.To), down: modify(ch.From)})
+
+		case "settings":
+			alters = append(alters, settingsSteps(name, ch.From, ch.To)...)
+
+		default:
+			// engine, sorting, primary and partition keys
+			gen.Warnings = append(gen.Warnings, fmt.Sprintf(
+				"%s %s cannot be changed in place from %q to %q, the table must be recreated and its data copied",
+				ch.Table, strings.ReplaceAll(ch.Kind, "_", " "), ch.From, ch.To,
+			))
+		}
+	}
+
+	var steps []step
+	for _, group := range [][]step{dropViews, createTables, alters, dropTables, createViews} {
+		steps = append(steps, group...)
+	}
+	for _, st := range steps {
+		gen.Up = append(gen.Up, terminate(st.up))
+	}
+	for i := len(steps) - 1; i >= 0; i-- {
+		gen.Down = append(gen.Down, terminate(steps[i].down))
+	}
+	return gen
+}
+
+// Render formats the migration as a mitch migration file
+func (gm *GeneratedMigration) Render(header string) string {
+	buf := new(strings.Builder)
+	for _, line := range strings.Split(header, "\n") {
+		fmt.Fprintf(buf, "-- %s\n", line)
+	}
+	for _, w := range gm.Warnings {
+		fmt.Fprintf(buf, "-- WARNING: %s\n", w)
+	}
+	buf.WriteString("\n")
+	for _, st := range gm.Up {
+		fmt.Fprintf(buf, "%s\n\n", st)
+	}
+	buf.WriteString("/* rollback\n")
+	for _, st := range gm.Down {
+		fmt.Fprintf(buf, "%s\n", st)
+	}
+	buf.WriteString("*/\n")
+	return buf.String()
+}
+
+// NextMigrationName returns the file name of a new migration in dir, numbered
+// after the highest existing version and padded like the existing files
+func NextMigrationName(dir fs.FS, name string) (string, error) {
+	files, err := fs.Glob(dir, "*.sql")
+	if err != nil {
+		return "", err
+	}
+
+	var latest int64
+	width := 3
+	for _, fname := range files {
+		id, err := ParseVersion(fname)
+		if err != nil {
+			continue
+		}
+		if id > latest {
+			latest = id
+		}
+		if w := len(rgxVerPrefix.FindString(fname)); w > width {
+			width = w
+		}
+	}
+	return fmt.Sprintf("%0*d_%s.sql", width, latest+1, name), nil
+}
+
+func modifyColumn(table, col, from, to string) string {
+	fromType, fromDefault := splitDefinition(from)
+	toType, toDefault := splitDefinition(to)
+	if toDefault == "" && fromDefault != "" {
+		// MODIFY COLUMN keeps an existing default unless explicitly removed
+		if fromType == toType {
+			return fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s REMOVE DEFAULT;", table, col)
+		}
+		return fmt.Sprintf(
+			"ALTER TABLE %s MODIFY COLUMN %s REMOVE DEFAULT, MODIFY COLUMN %s %s;",
+			table, col, col, toType,
+		)
+	}
+	return fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s;", table, col, to)
+}
+
+// splitDefinition splits a column definition into its type and default clause
+func splitDefinition(def string) (typ, dflt string) {
+	for _, kind := range []string{" DEFAULT ", " MATERIALIZED ", " ALIAS ", " EPHEMERAL"} {
+		if i := strings.Index(def, kind); i != -1 {
+			return def[:i], strings.TrimSpace(def[i:])
+		}
+	}
+	return def, ""
+}
+
+// settingsSteps modifies settings that were added or changed and resets
+// settings that were removed
+func settingsSteps(table, from, to string) []step {
+	fromKV, toKV := parseSettings(from), parseSettings(to)
+	var keys []string
+	for k := range fromKV {
+		keys = append(keys, k)
+	}
+	for k := range toKV {
+		if _, ok := fromKV[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	set := func(k, v string) string {
+		if v == "" {
+			return fmt.Sprintf("ALTER TABLE %s RESET SETTING %s;", table, k)
+		}
+		return fmt.Sprintf("ALTER TABLE %s MODIFY SETTING %s = %s;", table, k, v)
+	}
+	var steps []step
+	for _, k := range keys {
+		if fromKV[k] == toKV[k] {
+			continue
+		}
+		steps = append(steps, step{up: set(k, toKV[k]), down: set(k, fromKV[k])})
+	}
+	return steps
+}
+
+func parseSettings(settings string) map[string]string {
+	kv := make(map[string]string)
+	for _, pair := range strings.Split(settings, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if ok {
+			kv[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return kv
+}
+
+func dropStatement(t *Table) string {
+	switch t.Engine {
+	case "Dictionary":
+		return fmt.Sprintf("DROP DICTIONARY IF EXISTS %s;", quoteIdent(t.Name))
+	case "View", "MaterializedView", "LiveView", "WindowView":
+		return fmt.Sprintf("DROP VIEW IF EXISTS %s;", quoteIdent(t.Name))
+	default:
+		return fmt.Sprintf("DROP TABLE IF EXISTS %s;", quoteIdent(t.Name))
+	}
+}
+
+func terminate(stmt string) string {
+	stmt = strings.TrimSpace(stmt)
+	if strings.HasSuffix(stmt, ";") {
+		return stmt
+	}
+	return stmt + ";"
+}
+
+func quoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
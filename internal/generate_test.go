@@ -0,0 +1,72 @@
+package internal_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateMigration(t *testing.T) {
+	current := &internal.Schema{Tables: map[string]*internal.Table{
+		"users": {
+			Name:       "users",
+			Engine:     "MergeTree",
+			SortingKey: "id",
+			Columns: []internal.Column{
+				{Name: "id", Type: "UInt64"},
+				{Name: "score", Type: "Decimal(10, 2)", DefaultKind: "DEFAULT", DefaultExpression: "0"},
+			},
+			CreateQuery: "CREATE TABLE users (`id` UInt64, `score` Decimal(10, 2) DEFAULT 0) ENGINE = MergeTree ORDER BY id",
+		},
+	}}
+	desired := &internal.Schema{Tables: map[string]*internal.Table{
+		"users": {
+			Name:       "users",
+			Engine:     "MergeTree",
+			SortingKey: "(id, email)",
+			TTL:        "created_at + toIntervalDay(30)",
+			Columns: []internal.Column{
+				{Name: "id", Type: "UInt64"},
+				{Name: "score", Type: "Decimal(12, 2)"},
+				{Name: "email", Type: "String"},
+			},
+		},
+		"posts": {
+			Name:        "posts",
+			Engine:      "MergeTree",
+			CreateQuery: "CREATE TABLE posts (`id` UInt64) ENGINE = MergeTree ORDER BY id",
+		},
+	}}
+
+	reqrd := require.New(t)
+	as := assert.New(t)
+	gen := internal.GenerateMigration(current, desired)
+	reqrd.Len(gen.Warnings, 1)
+	as.Contains(gen.Warnings[0], "users sorting key cannot be changed in place")
+	as.Equal([]string{
+		"CREATE TABLE posts (`id` UInt64) ENGINE = MergeTree ORDER BY id;",
+		"ALTER TABLE `users` MODIFY TTL created_at + toIntervalDay(30);",
+		"ALTER TABLE `users` MODIFY COLUMN `score` REMOVE DEFAULT, MODIFY COLUMN `score` Decimal(12, 2);",
+		"ALTER TABLE `users` ADD COLUMN IF NOT EXISTS `email` String;",
+	}, gen.Up)
+	as.Equal([]string{
+		"ALTER TABLE `users` DROP COLUMN IF EXISTS `email`;",
+		"ALTER TABLE `users` MODIFY COLUMN `score` Decimal(10, 2) DEFAULT 0;",
+		"ALTER TABLE `users` REMOVE TTL;",
+		"DROP TABLE IF EXISTS `posts`;",
+	}, gen.Down)
+}
+
+func TestNextMigrationName(t *testing.T) {
+	dir := fstest.MapFS{
+		"001_default_database.sql": {},
+		"009_posts_view.sql":       {},
+		"README.md":                {},
+	}
+	name, err := internal.NextMigrationName(dir, "add_email")
+	require.NoError(t, err)
+	assert.Equal(t, "010_add_email.sql", name)
+}
@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/arhyth/mitch"
+)
+
+// TableStats are the totals of the active parts of a table in system.parts
+type TableStats struct {
+	Database string
+	Table    string
+	Rows     uint64
+	Bytes    uint64
+}
+
+// DestructiveStatement is a destructive statement with the tables it affects
+type DestructiveStatement struct {
+	// Index is the 1-based position of the statement in its section
+	Index  int
+	SQL    string
+	Tables []TableStats
+}
+
+// DestructiveChange lists the destructive statements of a version about to run
+type DestructiveChange struct {
+	Version    int64
+	Source     string
+	Direction  MigrationDirection
+	Statements []DestructiveStatement
+}
+
+func (dc DestructiveChange) String() string {
+	buf := new(strings.Builder)
+	fmt.Fprintf(buf, "%s (%s) has destructive statements:\n", dc.Source, dc.Direction)
+	for _, st := range dc.Statements {
+		fmt.Fprintf(buf, "  statement %d: %s\n", st.Index, firstLine(st.SQL))
+		for _, ts := range st.Tables {
+			fmt.Fprintf(buf, "    %s.%s: %d rows, %d bytes\n", ts.Database, ts.Table, ts.Rows, ts.Bytes)
+		}
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// ConfirmFunc decides whether the destructive statements of a version may
+// run. Returning an error stops the run before any statement of the version.
+type ConfirmFunc func(ctx context.Context, change DestructiveChange) error
+
+// WithDestructiveGuard makes the runner call confirm before running a version
+// with destructive statements, see `Classify`
+func WithDestructiveGuard(confirm ConfirmFunc) RunnerOption {
+	return func(rr *Runner) {
+		rr.confirm = confirm
+	}
+}
+
+// RefuseDestructive is a ConfirmFunc refusing every destructive change
+func RefuseDestructive(_ context.Context, change DestructiveChange) error {
+	return fmt.Errorf("%w: %s", mitch.ErrDestructiveChange, change)
+}
+
+// guard asks the configured ConfirmFunc about the destructive statements of stmts
+func (rr *Runner) guard(ctx context.Context, ver Version, direction MigrationDirection, stmts []string) error {
+	if rr.confirm == nil {
+		return nil
+	}
+
+	change := DestructiveChange{
+		Version:   ver.ID,
+		Source:    ver.Source,
+		Direction: direction,
+	}
+	for idx, stmt := range stmts {
+		if Classify(stmt) != Destructive {
+			continue
+		}
+		stats, err := rr.tableStats(ctx, stmt)
+		if err != nil {
+			return err
+		}
+		change.Statements = append(change.Statements, DestructiveStatement{
+			Index:  idx + 1,
+			SQL:    stmt,
+			Tables: stats,
+		})
+	}
+	if len(change.Statements) == 0 {
+		return nil
+	}
+	return rr.confirm(ctx, change)
+}
+
+// guardAll runs the guard over every version before any of them runs, so that
+// refusing a later version does not leave the earlier ones applied
+func (rr *Runner) guardAll(ctx context.Context, vers Migration, direction MigrationDirection) error {
+	if rr.confirm == nil {
+		return nil
+	}
+	for _, ver := range vers {
+		section, err := rr.section(ver, direction)
+		if err != nil {
+			return err
+		}
+		if err := rr.guard(ctx, ver, direction, section.Statements); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableStats returns row and byte counts of the tables stmt affects, every
+// table of the database for DROP DATABASE
+func (rr *Runner) tableStats(ctx context.Context, stmt string) ([]TableStats, error) {
+	dbName, table := AffectedTable(stmt)
+	if dbName == "" && table == "" {
+		return nil, nil
+	}
+	if dbName == "" {
+		dbName = rr.GetDBName()
+	}
+
+	q := `
+		SELECT database, table, sum(rows), sum(bytes_on_disk)
+		FROM system.parts
+		WHERE active AND database = $1 AND ($2 = '' OR table = $2)
+		GROUP BY database, table
+		ORDER BY table;
+	`
+	rows, err := rr.db.QueryContext(ctx, q, dbName, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query table stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []TableStats
+	for rows.Next() {
+		var ts TableStats
+		if err := rows.Scan(&ts.Database, &ts.Table, &ts.Rows, &ts.Bytes); err != nil {
+			return nil, fmt.Errorf("failed to scan table stats: %w", err)
+		}
+		stats = append(stats, ts)
+	}
+	return stats, rows.Err()
+}
+
+func firstLine(stmt string) string {
+	line, _, more := strings.Cut(strings.TrimSpace(stmt), "\n")
+	if more {
+		return line + " ..."
+	}
+	return line
+}
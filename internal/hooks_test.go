@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"context"
+	"time"
+)
+
+// Unexported functions run by the tests of package internal_test
+var (
+	RunWithRetry  = (*Runner).withRetry
+	RunInsertOnce = (*Runner).insertOnce
+)
+
+// MutationRow is a row of system.mutations
+type MutationRow struct {
+	ID         string
+	Done       bool
+	PartsToDo  int64
+	FailReason string
+}
+
+// WaitMutations runs `mutationTracker.wait` on a table whose mutations are
+// polls, one per poll and the last one repeating
+func WaitMutations(ctx context.Context, rr *Runner, timeout time.Duration, polls ...[]MutationRow) ([]string, error) {
+	mt := &mutationTracker{rr: rr, database: "db", table: "events", timeout: timeout, known: make(map[string]bool)}
+	var n int
+	mt.poll = func(context.Context) ([]mutationState, error) {
+		rows := polls[min(n, len(polls)-1)]
+		n++
+		states := make([]mutationState, len(rows))
+		for i, row := range rows {
+			states[i] = mutationState{id: row.ID, done: row.Done, partsToDo: row.PartsToDo, failReason: row.FailReason}
+		}
+		return states, nil
+	}
+	return mt.wait(ctx)
+}
@@ -0,0 +1,466 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/arhyth/mitch"
+	"github.com/rs/zerolog/log"
+)
+
+// ForeignTool describes the history table and file layout of another migration tool
+type ForeignTool struct {
+	Name string
+	// Table is the default name of the tool's history table
+	Table string
+	// applied returns the versions recorded as applied in the history table.
+	// available are the versions of the migration directory, for tools that
+	// record a single current or baseline version.
+	applied func(ctx context.Context, db *sql.DB, table string, available []int64) ([]int64, error)
+	// read parses the tool's migration files in dir
+	read func(dir fs.FS) ([]ForeignFile, error)
+}
+
+// ForeignFile is a migration of another tool, converted to mitch's format
+type ForeignFile struct {
+	Version     int64
+	Description string
+	Up, Down    string
+}
+
+var (
+	rgxGooseFile   = regexp.MustCompile(`^([0-9]+)_(.*)\.sql$`)
+	rgxMigrateFile = regexp.MustCompile(`^([0-9]+)_(.*)\.(up|down)\.sql$`)
+	rgxFlywayFile  = regexp.MustCompile(`^([VU])([^_]+)__(.*)\.sql$`)
+)
+
+// ForeignTools are the migration tools whose history can be imported
+var ForeignTools = map[string]ForeignTool{
+	"goose": {
+		Name:    "goose",
+		Table:   "goose_db_version",
+		applied: gooseApplied,
+		read:    readGoose,
+	},
+	"migrate": {
+		Name:    "migrate",
+		Table:   "schema_migrations",
+		applied: migrateApplied,
+		read:    readMigrate,
+	},
+	"flyway": {
+		Name:    "flyway",
+		Table:   "flyway_schema_history",
+		applied: flywayApplied,
+		read:    readFlyway,
+	},
+}
+
+// LookupForeignTool returns the tool named name, one of goose, migrate or flyway
+func LookupForeignTool(name string) (ForeignTool, error) {
+	tool, ok := ForeignTools[name]
+	if !ok {
+		return ForeignTool{}, fmt.Errorf("%w: %q, expected goose, migrate or flyway", mitch.ErrUnknownTool, name)
+	}
+	return tool, nil
+}
+
+// ReadFiles parses the tool's migration files in dir, ordered by version
+func (ft ForeignTool) ReadFiles(dir fs.FS) ([]ForeignFile, error) {
+	files, err := ft.read(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Version < files[j].Version
+	})
+	return files, nil
+}
+
+// ConvertForeign writes files as mitch migrations to dir. Existing files are
+// not overwritten.
+func ConvertForeign(files []ForeignFile, dir string) ([]string, error) {
+	width := 3
+	for _, ff := range files {
+		if w := len(strconv.FormatInt(ff.Version, 10)); w > width {
+			width = w
+		}
+	}
+
+	var written []string
+	for _, ff := range files {
+		fname := fmt.Sprintf("%0*d_%s.sql", width, ff.Version, ff.Description)
+		file, err := os.OpenFile(filepath.Join(dir, fname), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return written, fmt.Errorf("failed to write converted migration: %w", err)
+		}
+		_, err = file.WriteString(ff.Render())
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return written, fmt.Errorf("failed to write converted migration: %w", err)
+		}
+		written = append(written, fname)
+	}
+	return written, nil
+}
+
+// Render returns the migration in mitch's format
+func (ff ForeignFile) Render() string {
+	buf := new(strings.Builder)
+	buf.WriteString(strings.TrimSpace(ff.Up))
+	buf.WriteString("\n")
+	if down := strings.TrimSpace(ff.Down); down != "" {
+		buf.WriteString("\n/* rollback\n")
+		buf.WriteString(down)
+		buf.WriteString("\n*/\n")
+	}
+	return buf.String()
+}
+
+// Import records the versions applied according to the tool's history table
+// in the version table, without running them. The migration directory must
+// hold mitch migrations for every applied version, eg. from `ConvertForeign`,
+// so that the recorded content hashes match. Versions already recorded are
+// skipped. It returns the recorded versions.
+func (rr *Runner) Import(ctx context.Context, tool ForeignTool, table string) (Migration, error) {
+	if table == "" {
+		table = tool.Table
+	}
+	found, err := rr.CollectMigration()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]Version, len(found))
+	available := make([]int64, 0, len(found))
+	for _, ver := range found {
+		byID[ver.ID] = ver
+		available = append(available, ver.ID)
+	}
+
+	applied, err := tool.applied(ctx, rr.db, table, available)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s history: %w", tool.Name, err)
+	}
+
+	// every version must have a file before any is recorded, a partial import
+	// would leave the history half converted
+	var missing []string
+	for _, id := range applied {
+		if _, ok := byID[id]; !ok {
+			missing = append(missing, strconv.FormatInt(id, 10))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: versions %s", mitch.ErrMissingVersionFile, strings.Join(missing, ", "))
+	}
+
+	if err := rr.MustVersionTable(ctx); err != nil {
+		return nil, err
+	}
+	dbVersions, err := rr.ListDBVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	recorded := make(map[int64]bool, len(dbVersions))
+	for _, ver := range dbVersions {
+		recorded[ver.ID] = true
+	}
+
+	var imported Migration
+	for _, id := range applied {
+		ver := byID[id]
+		if recorded[id] {
+			continue
+		}
+
+		tx, err := rr.db.BeginTx(ctx, nil)
+		if err != nil {
+			return imported, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		if err := rr.InsertVersion(ctx, tx, ver); err != nil {
+			_ = tx.Rollback()
+			return imported, fmt.Errorf("failed to insert new version: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return imported, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		log.Info().
+			Int64("version", ver.ID).
+			Str("source", ver.Source).
+			Msgf("mitch: imported version from %s", tool.Name)
+		imported = append(imported, ver)
+	}
+	return imported, nil
+}
+
+// gooseApplied reads goose_db_version, where the latest row of a version tells
+// whether it is applied
+func gooseApplied(ctx context.Context, db *sql.DB, table string, _ []int64) ([]int64, error) {
+	q := fmt.Sprintf("SELECT version_id, toUInt8(is_applied) FROM %s ORDER BY id;", table)
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	state := make(map[int64]bool)
+	for rows.Next() {
+		var (
+			id        int64
+			isApplied uint8
+		)
+		if err := rows.Scan(&id, &isApplied); err != nil {
+			return nil, err
+		}
+		state[id] = isApplied == 1
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var applied []int64
+	for id, ok := range state {
+		// goose records version 0 on creating its table
+		if ok && id > 0 {
+			applied = append(applied, id)
+		}
+	}
+	sort.Slice(applied, func(i, j int) bool { return applied[i] < applied[j] })
+	return applied, nil
+}
+
+// migrateApplied reads golang-migrate's schema_migrations, which only records
+// the current version: every version up to it is applied
+func migrateApplied(ctx context.Context, db *sql.DB, table string, available []int64) ([]int64, error) {
+	q := fmt.Sprintf("SELECT version, toUInt8(dirty) FROM %s ORDER BY sequence DESC LIMIT 1;", table)
+	var (
+		current int64
+		dirty   uint8
+	)
+	err := db.QueryRowContext(ctx, q).Scan(&current, &dirty)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if dirty == 1 {
+		return nil, fmt.Errorf("%w: version %d", mitch.ErrDirtyHistory, current)
+	}
+	return upTo(available, current), nil
+}
+
+// flywayApplied reads flyway_schema_history in install order. A baseline row
+// applies every version up to it and an undo row reverts its version.
+func flywayApplied(ctx context.Context, db *sql.DB, table string, available []int64) ([]int64, error) {
+	q := fmt.Sprintf("SELECT version, type, toUInt8(success) FROM %s ORDER BY installed_rank;", table)
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	state := make(map[int64]bool)
+	for rows.Next() {
+		var (
+			version sql.NullString
+			kind    string
+			success uint8
+		)
+		if err := rows.Scan(&version, &kind, &success); err != nil {
+			return nil, err
+		}
+		if !version.Valid || success != 1 {
+			// repeatable migrations have no version
+			continue
+		}
+		id, err := flywayVersion(version.String)
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case "BASELINE":
+			for _, v := range upTo(available, id) {
+				state[v] = true
+			}
+		case "UNDO_SQL":
+			state[id] = false
+		default:
+			state[id] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var applied []int64
+	for id, ok := range state {
+		if ok {
+			applied = append(applied, id)
+		}
+	}
+	sort.Slice(applied, func(i, j int) bool { return applied[i] < applied[j] })
+	return applied, nil
+}
+
+func upTo(available []int64, current int64) []int64 {
+	var ids []int64
+	for _, id := range available {
+		if id <= current {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// flywayVersion converts a flyway version to mitch's integer versions, dotted
+// versions such as 1.1 have no equivalent
+func flywayVersion(version string) (int64, error) {
+	id, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", mitch.ErrForeignVersion, version)
+	}
+	return id, nil
+}
+
+func readGoose(dir fs.FS) ([]ForeignFile, error) {
+	names, err := fs.Glob(dir, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var files []ForeignFile
+	for _, name := range names {
+		m := rgxGooseFile.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		id, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", mitch.ErrForeignVersion, name)
+		}
+		content, err := fs.ReadFile(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		up, down := splitGoose(string(content))
+		files = append(files, ForeignFile{Version: id, Description: m[2], Up: up, Down: down})
+	}
+	return files, nil
+}
+
+// splitGoose splits a goose file on its `-- +goose Up` and `-- +goose Down`
+// directives, dropping the other directives
+func splitGoose(content string) (up, down string) {
+	var (
+		section *[]string
+		ups     []string
+		downs   []string
+	)
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "-- +goose Up"):
+			section = &ups
+			continue
+		case strings.HasPrefix(trimmed, "-- +goose Down"):
+			section = &downs
+			continue
+		case strings.HasPrefix(trimmed, "-- +goose"):
+			continue
+		}
+		if section != nil {
+			*section = append(*section, line)
+		}
+	}
+	return strings.Join(ups, "\n"), strings.Join(downs, "\n")
+}
+
+func readMigrate(dir fs.FS) ([]ForeignFile, error) {
+	names, err := fs.Glob(dir, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]*ForeignFile)
+	for _, name := range names {
+		m := rgxMigrateFile.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		id, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", mitch.ErrForeignVersion, name)
+		}
+		content, err := fs.ReadFile(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		ff, ok := byID[id]
+		if !ok {
+			ff = &ForeignFile{Version: id, Description: m[2]}
+			byID[id] = ff
+		}
+		if m[3] == "up" {
+			ff.Up = string(content)
+		} else {
+			ff.Down = string(content)
+		}
+	}
+
+	files := make([]ForeignFile, 0, len(byID))
+	for _, ff := range byID {
+		files = append(files, *ff)
+	}
+	return files, nil
+}
+
+func readFlyway(dir fs.FS) ([]ForeignFile, error) {
+	names, err := fs.Glob(dir, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]*ForeignFile)
+	for _, name := range names {
+		m := rgxFlywayFile.FindStringSubmatch(name)
+		if m == nil {
+			// eg. repeatable `R__` migrations, which have no version
+			continue
+		}
+		id, err := flywayVersion(m[2])
+		if err != nil {
+			return nil, err
+		}
+		content, err := fs.ReadFile(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		ff, ok := byID[id]
+		if !ok {
+			ff = &ForeignFile{Version: id, Description: strings.ReplaceAll(m[3], " ", "_")}
+			byID[id] = ff
+		}
+		if m[1] == "V" {
+			ff.Up = string(content)
+		} else {
+			ff.Down = string(content)
+		}
+	}
+
+	files := make([]ForeignFile, 0, len(byID))
+	for _, ff := range byID {
+		files = append(files, *ff)
+	}
+	return files, nil
+}
@@ -0,0 +1,92 @@
+package internal_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForeignToolReadFiles(t *testing.T) {
+	t.Run("goose", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		as := assert.New(tt)
+		tool, err := internal.LookupForeignTool("goose")
+		reqrd.NoError(err)
+		dir := fstest.MapFS{
+			"00002_add_field.sql": {Data: []byte("-- +goose Up\n-- +goose StatementBegin\n" + anotherUpSQL + "\n-- +goose StatementEnd\n\n-- +goose Down\nALTER TABLE test_table DROP COLUMN NewField;\n")},
+			"00001_init.sql":      {Data: []byte("-- +goose Up\n" + sampleUpSQL + "\n")},
+		}
+		files, err := tool.ReadFiles(dir)
+		reqrd.NoError(err)
+		reqrd.Len(files, 2)
+		as.Equal(int64(1), files[0].Version)
+		as.Equal("add_field", files[1].Description)
+
+		ver, err := internal.ParseMigration(strings.NewReader(files[1].Render()))
+		reqrd.NoError(err)
+		as.Equal([]string{anotherUpSQL}, ver.Up.Statements)
+		as.Equal([]string{"ALTER TABLE test_table DROP COLUMN NewField;"}, ver.Down.Statements)
+	})
+
+	t.Run("migrate", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		as := assert.New(tt)
+		tool, err := internal.LookupForeignTool("migrate")
+		reqrd.NoError(err)
+		dir := fstest.MapFS{
+			"3_add_field.up.sql":   {Data: []byte(anotherUpSQL)},
+			"3_add_field.down.sql": {Data: []byte("ALTER TABLE test_table DROP COLUMN NewField;")},
+		}
+		files, err := tool.ReadFiles(dir)
+		reqrd.NoError(err)
+		reqrd.Len(files, 1)
+		as.Equal(anotherUpSQL, files[0].Up)
+		as.NotEmpty(files[0].Down)
+	})
+
+	t.Run("flyway", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		as := assert.New(tt)
+		tool, err := internal.LookupForeignTool("flyway")
+		reqrd.NoError(err)
+		files, err := tool.ReadFiles(fstest.MapFS{
+			"V4__add field.sql":   {Data: []byte(anotherUpSQL)},
+			"R__refresh_view.sql": {Data: []byte("SELECT 1;")},
+		})
+		reqrd.NoError(err)
+		reqrd.Len(files, 1)
+		as.Equal("add_field", files[0].Description)
+
+		_, err = tool.ReadFiles(fstest.MapFS{"V1.1__fix.sql": {}})
+		as.Error(err)
+	})
+
+	t.Run("unknown tool", func(tt *testing.T) {
+		_, err := internal.LookupForeignTool("liquibase")
+		assert.Error(tt, err)
+	})
+}
+
+func TestConvertForeign(t *testing.T) {
+	reqrd := require.New(t)
+	as := assert.New(t)
+	dir := t.TempDir()
+	files := []internal.ForeignFile{{Version: 2, Description: "add_field", Up: anotherUpSQL}}
+
+	written, err := internal.ConvertForeign(files, dir)
+	reqrd.NoError(err)
+	as.Equal([]string{"002_add_field.sql"}, written)
+	content, err := os.ReadFile(filepath.Join(dir, "002_add_field.sql"))
+	reqrd.NoError(err)
+	as.Equal(anotherUpSQL+"\n", string(content))
+
+	// existing migrations are not overwritten
+	_, err = internal.ConvertForeign(files, dir)
+	as.Error(err)
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes reports as a JUnit XML document, one test suite per report
+// and one test case per version. With perStatement, every statement is a test
+// case of its own instead.
+func WriteJUnit(w io.Writer, reports []*Report, perStatement bool) error {
+	doc := junitTestSuites{}
+	for _, r := range reports {
+		doc.Suites = append(doc.Suites, junitSuite(r, perStatement))
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func junitSuite(r *Report, perStatement bool) junitTestSuite {
+	name := "mitch " + r.Direction
+	if r.Target != "" {
+		name = fmt.Sprintf("%s %s", name, r.Target)
+	}
+	suite := junitTestSuite{
+		Name:      name,
+		Time:      seconds(millis(r.FinishedAt.Sub(r.StartedAt))),
+		Timestamp: r.StartedAt.Format("2006-01-02T15:04:05"),
+	}
+
+	var failedVersion bool
+	for _, vr := range r.Versions {
+		classname := fmt.Sprintf("%s.%s", vr.Source, vr.Direction)
+		if !perStatement {
+			tc := junitTestCase{
+				Name:      fmt.Sprintf("%s (%s)", vr.Source, vr.Direction),
+				Classname: classname,
+				Time:      seconds(vr.DurationMS),
+			}
+			if vr.Error != "" {
+				failedVersion = true
+				tc.Failure = &junitFailure{
+					Message: vr.Error,
+					Type:    "MigrationError",
+					Text:    failureText(vr),
+				}
+			}
+			suite.Cases = append(suite.Cases, tc)
+			continue
+		}
+
+		for _, sr := range vr.Statements {
+			tc := junitTestCase{
+				Name:      fmt.Sprintf("%s (%s) statement %d", vr.Source, vr.Direction, sr.Index),
+				Classname: classname,
+				Time:      seconds(sr.DurationMS),
+			}
+			if sr.Error != "" {
+				tc.Failure = &junitFailure{
+					Message: sr.Error,
+					Type:    "StatementError",
+					Text:    sr.Error,
+				}
+			}
+			suite.Cases = append(suite.Cases, tc)
+		}
+		if vr.Error != "" && vr.FailedStatement == 0 {
+			// failures outside statements, eg. version bookkeeping
+			failedVersion = true
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Name:      fmt.Sprintf("%s (%s)", vr.Source, vr.Direction),
+				Classname: classname,
+				Time:      seconds(vr.DurationMS),
+				Failure: &junitFailure{
+					Message: vr.Error,
+					Type:    "MigrationError",
+					Text:    vr.Error,
+				},
+			})
+		}
+		if vr.FailedStatement != 0 {
+			failedVersion = true
+		}
+	}
+
+	if r.Error != "" && !failedVersion {
+		// the run failed before or between versions, eg. listing versions
+		suite.Cases = append(suite.Cases, junitTestCase{
+			Name:      "mitch",
+			Classname: "mitch",
+			Time:      "0.000",
+			Failure: &junitFailure{
+				Message: r.Error,
+				Type:    "RunError",
+				Text:    r.Error,
+			},
+		})
+	}
+
+	suite.Tests = len(suite.Cases)
+	for _, tc := range suite.Cases {
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+	}
+	return suite
+}
+
+func failureText(vr VersionReport) string {
+	if vr.FailedStatement == 0 {
+		return vr.Error
+	}
+	return fmt.Sprintf("statement %d failed\n%s", vr.FailedStatement, vr.Error)
+}
+
+func seconds(ms float64) string {
+	return fmt.Sprintf("%.3f", ms/1000)
+}
@@ -0,0 +1,62 @@
+package internal_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteJUnit(t *testing.T) {
+	started := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	report := &internal.Report{
+		Direction:  "up",
+		StartedAt:  started,
+		FinishedAt: started.Add(1500 * time.Millisecond),
+		Versions: []internal.VersionReport{
+			{
+				ID:         1,
+				Source:     "001_default_database.sql",
+				Direction:  "up",
+				DurationMS: 120,
+				Statements: []internal.StatementReport{{Index: 1, DurationMS: 120}},
+			},
+			{
+				ID:         3,
+				Source:     "003_comments_table.sql",
+				Direction:  "up",
+				DurationMS: 40,
+				Statements: []internal.StatementReport{
+					{Index: 1, DurationMS: 40, Error: "code: 57, message: Table default.comments already exists"},
+				},
+				Error:           "failed to execute SQL: 003_comments_table.sql statement 1: code: 57",
+				FailedStatement: 1,
+			},
+		},
+		Error: "failed to execute SQL: 003_comments_table.sql statement 1: code: 57",
+	}
+
+	t.Run("per version", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		as := assert.New(tt)
+		out := new(bytes.Buffer)
+		reqrd.NoError(internal.WriteJUnit(out, []*internal.Report{report}, false))
+		xml := out.String()
+		as.Contains(xml, `<testsuite name="mitch up" tests="2" failures="1" time="1.500"`)
+		as.Contains(xml, `<testcase name="001_default_database.sql (up)" classname="001_default_database.sql.up" time="0.120"></testcase>`)
+		as.Contains(xml, `statement 1 failed`)
+	})
+
+	t.Run("per statement", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		as := assert.New(tt)
+		out := new(bytes.Buffer)
+		reqrd.NoError(internal.WriteJUnit(out, []*internal.Report{report}, true))
+		xml := out.String()
+		as.Contains(xml, `<testcase name="003_comments_table.sql (up) statement 1"`)
+		as.Contains(xml, `message="code: 57, message: Table default.comments already exists"`)
+	})
+}
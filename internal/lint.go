@@ -0,0 +1,403 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/arhyth/mitch"
+)
+
+// Severity of a lint finding, named after SARIF result levels
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityNote    Severity = "note"
+)
+
+// LintRule checks the statements of a migration for a risky pattern
+type LintRule struct {
+	ID          string
+	Description string
+	Severity    Severity
+	// file rules check a version once, statement rules check every statement
+	file      func(ver Version) string
+	statement func(stmt string, cfg LintConfig) string
+}
+
+// LintConfig selects and tunes lint rules
+type LintConfig struct {
+	// Disabled are IDs of rules that are not run
+	Disabled []string
+	// Severities overrides the default severity of rules by ID
+	Severities map[string]Severity
+	// Cluster enables rules for migrations run with `ON CLUSTER` DDL
+	Cluster bool
+}
+
+// Finding is a lint rule violation in a migration file
+type Finding struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Source   string   `json:"source"`
+	Version  int64    `json:"version"`
+	// Section is `up` or `down`, empty for file findings
+	Section string `json:"section,omitempty"`
+	// Statement is the 1-based index of the statement in its section
+	Statement int    `json:"statement,omitempty"`
+	Line      int    `json:"line"`
+	Message   string `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s [%s]", f.Source, f.Line, f.Severity, f.Message, f.Rule)
+}
+
+var (
+	rgxNullableColumn = regexp.MustCompile("(?m)^\\s*`?(\\w+)`?\\s+Nullable\\(")
+	rgxOrderBy        = regexp.MustCompile(`(?is)\bORDER\s+BY\s+(.*?)(\bPRIMARY\s+KEY\b|\bPARTITION\s+BY\b|\bSAMPLE\s+BY\b|\bTTL\b|\bSETTINGS\b|;|$)`)
+	rgxIdent          = regexp.MustCompile(`\w+`)
+	rgxNotNull        = regexp.MustCompile(`(?i)\bNOT\s+NULL\b`)
+	rgxIsBefore       = regexp.MustCompile(`(?i)\bIS\s*$`)
+)
+
+// ruleInvalidFile is the only rule checking files that failed to parse
+const ruleInvalidFile = "invalid-file"
+
+// LintRules are the rules `Lint` runs
+var LintRules = []LintRule{
+	{
+		ID:          ruleInvalidFile,
+		Description: "Migration file cannot be parsed",
+		Severity:    SeverityError,
+		file: func(ver Version) string {
+			if ver.Err == nil {
+				return ""
+			}
+			return fmt.Sprintf("file cannot be parsed: %v", ver.Err)
+		},
+	},
+	{
+		ID:          "missing-rollback",
+		Description: "Version has no rollback section",
+		Severity:    SeverityWarning,
+		file: func(ver Version) string {
+			if len(ver.Down.Statements) > 0 || ver.IsBaseline() || ver.IsIrreversible() {
+				return ""
+			}
+			return "version has no rollback section, mark it `-- +mitch irreversible` if intended"
+		},
+	},
+	{
+		ID:          "drop-without-if-exists",
+		Description: "DROP or TRUNCATE without IF EXISTS",
+		Severity:    SeverityWarning,
+		statement: func(stmt string, _ LintConfig) string {
+			norm := normaliseStatement(stmt)
+			if !rgxDrop.MatchString(norm) || strings.Contains(norm, " IF EXISTS ") {
+				return ""
+			}
+			return "DROP or TRUNCATE without IF EXISTS fails when the object is missing"
+		},
+	},
+	{
+		ID:          "mutation-without-sync",
+		Description: "ALTER DELETE or UPDATE mutation without mutations_sync",
+		Severity:    SeverityWarning,
+		statement: func(stmt string, _ LintConfig) string {
+			norm := normaliseStatement(stmt)
+			if !rgxMutation.MatchString(norm) || strings.Contains(norm, "MUTATIONS_SYNC") {
+				return ""
+			}
+			return "mutation runs asynchronously, set mutations_sync to wait for it"
+		},
+	},
+	{
+		ID:          "missing-on-cluster",
+		Description: "CREATE without ON CLUSTER in cluster mode",
+		Severity:    SeverityWarning,
+		statement: func(stmt string, cfg LintConfig) string {
+			norm := normaliseStatement(stmt)
+			if !cfg.Cluster || !rgxCreate.MatchString(norm) || strings.Contains(norm, " ON CLUSTER ") {
+				return ""
+			}
+			return "CREATE without ON CLUSTER only runs on the connected host"
+		},
+	},
+	{
+		ID:          "nullable-sorting-key",
+		Description: "Nullable column in ORDER BY",
+		Severity:    SeverityError,
+		statement: func(stmt string, _ LintConfig) string {
+			if !strings.HasPrefix(normaliseStatement(stmt), "CREATE TABLE ") {
+				return ""
+			}
+			m := rgxOrderBy.FindStringSubmatch(stmt)
+			if m == nil {
+				return ""
+			}
+			keyIdents := make(map[string]bool)
+			for _, ident := range rgxIdent.FindAllString(m[1], -1) {
+				keyIdents[ident] = true
+			}
+			for _, col := range rgxNullableColumn.FindAllStringSubmatch(stmt, -1) {
+				if keyIdents[col[1]] {
+					return fmt.Sprintf("Nullable column %s in ORDER BY requires allow_nullable_key and hurts performance", col[1])
+				}
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "not-null-syntax",
+		Description: "NOT NULL column syntax",
+		Severity:    SeverityWarning,
+		statement: func(stmt string, _ LintConfig) string {
+			norm := normaliseStatement(stmt)
+			if !strings.HasPrefix(norm, "CREATE ") && !strings.HasPrefix(norm, "ALTER ") {
+				return ""
+			}
+			for _, loc := range rgxNotNull.FindAllStringIndex(stmt, -1) {
+				if !rgxIsBefore.MatchString(stmt[:loc[0]]) {
+					return "columns are not nullable unless declared Nullable(T), NOT NULL is redundant"
+				}
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "non-idempotent",
+		Description: "Statement fails or changes data when run twice",
+		Severity:    SeverityNote,
+		statement: func(stmt string, _ LintConfig) string {
+			if IsIdempotent(stmt) {
+				return ""
+			}
+			return "statement is not idempotent, a partially applied version cannot be re-run"
+		},
+	},
+}
+
+// Validate checks that the rules and severities of the config exist
+func (cfg LintConfig) Validate() error {
+	known := make(map[string]bool, len(LintRules))
+	for _, rule := range LintRules {
+		known[rule.ID] = true
+	}
+	for _, id := range cfg.Disabled {
+		if !known[id] {
+			return fmt.Errorf("%w: %q", mitch.ErrUnknownLintRule, id)
+		}
+	}
+	for id, severity := range cfg.Severities {
+		if !known[id] {
+			return fmt.Errorf("%w: %q", mitch.ErrUnknownLintRule, id)
+		}
+		switch severity {
+		case SeverityError, SeverityWarning, SeverityNote:
+		default:
+			return fmt.Errorf("%w: %q, expected error, warning or note", mitch.ErrInvalidSeverity, severity)
+		}
+	}
+	return nil
+}
+
+// Lint runs the enabled rules over the statements of ms. Findings can be
+// suppressed with a `-- +mitch nolint` directive, optionally followed by rule
+// IDs, directly above a statement or in the file header for the whole file.
+func Lint(ms Migration, cfg LintConfig) []Finding {
+	disabled := make(map[string]bool, len(cfg.Disabled))
+	for _, id := range cfg.Disabled {
+		disabled[id] = true
+	}
+
+	var findings []Finding
+	for _, ver := range ms {
+		for _, rule := range LintRules {
+			if disabled[rule.ID] || suppressed(ver.Annotations, rule.ID) {
+				continue
+			}
+			if ver.Err != nil && rule.ID != ruleInvalidFile {
+				// nothing else to check, see `CollectLintable`
+				continue
+			}
+			severity := rule.Severity
+			if s, ok := cfg.Severities[rule.ID]; ok {
+				severity = s
+			}
+
+			if rule.file != nil {
+				if msg := rule.file(ver); msg != "" {
+					findings = append(findings, Finding{
+						Rule:     rule.ID,
+						Severity: severity,
+						Source:   ver.Source,
+						Version:  ver.ID,
+						Line:     1,
+						Message:  msg,
+					})
+				}
+				continue
+			}
+
+			type lintSection struct {
+				name string
+				sql  *SQL
+			}
+			sections := []lintSection{{Up.String(), ver.Up}, {Down.String(), ver.Down}}
+			for _, alt := range ver.Alternatives {
+				sections = append(sections, lintSection{Up.String(), alt.Up})
+			}
+			for _, section := range sections {
+				for idx, stmt := range section.sql.Statements {
+					if suppressed(section.sql.AnnotationsAt(idx), rule.ID) {
+						continue
+					}
+					msg := rule.statement(stmt, cfg)
+					if msg == "" {
+						continue
+					}
+					findings = append(findings, Finding{
+						Rule:      rule.ID,
+						Severity:  severity,
+						Source:    ver.Source,
+						Version:   ver.ID,
+						Section:   section.name,
+						Statement: idx + 1,
+						Line:      section.sql.Line(idx),
+						Message:   msg,
+					})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Source != findings[j].Source {
+			return findings[i].Source < findings[j].Source
+		}
+		return findings[i].Line < findings[j].Line
+	})
+	return findings
+}
+
+// suppressed reports whether anns has a `nolint` directive for rule
+func suppressed(anns Annotations, rule string) bool {
+	for _, ann := range anns {
+		if ann.Key != "nolint" {
+			continue
+		}
+		if ann.Value == "" {
+			return true
+		}
+		for _, id := range strings.Split(ann.Value, ",") {
+			if strings.TrimSpace(id) == rule {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WriteLintText writes findings one per line
+func WriteLintText(w io.Writer, findings []Finding) error {
+	for _, f := range findings {
+		if _, err := fmt.Fprintln(w, f.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string       `json:"id"`
+	ShortDescription     sarifMessage `json:"shortDescription"`
+	DefaultConfiguration struct {
+		Level Severity `json:"level"`
+	} `json:"defaultConfiguration"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     Severity        `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region struct {
+			StartLine int `json:"startLine"`
+		} `json:"region"`
+	} `json:"physicalLocation"`
+}
+
+// WriteSARIF writes findings as a SARIF 2.1.0 log. File locations are
+// relative to dir, the migration directory.
+func WriteSARIF(w io.Writer, findings []Finding, dir string) error {
+	driver := sarifDriver{
+		Name:           "mitch",
+		InformationURI: "https://github.com/arhyth/mitch",
+		Rules:          make([]sarifRule, 0, len(LintRules)),
+	}
+	for _, rule := range LintRules {
+		sr := sarifRule{ID: rule.ID, ShortDescription: sarifMessage{Text: rule.Description}}
+		sr.DefaultConfiguration.Level = rule.Severity
+		driver.Rules = append(driver.Rules, sr)
+	}
+
+	results := make([]sarifResult, 0, len(findings))
+	for _, f := range findings {
+		var loc sarifLocation
+		loc.PhysicalLocation.ArtifactLocation.URI = path.Join(dir, f.Source)
+		loc.PhysicalLocation.Region.StartLine = max(f.Line, 1)
+		results = append(results, sarifResult{
+			RuleID:    f.Rule,
+			Level:     f.Severity,
+			Message:   sarifMessage{Text: f.Message},
+			Locations: []sarifLocation{loc},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	})
+}
@@ -0,0 +1,183 @@
+package internal_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/arhyth/mitch"
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lintFile(tt *testing.T, content string, cfg internal.LintConfig) []internal.Finding {
+	ver, err := internal.ParseMigration(strings.NewReader(content))
+	require.NoError(tt, err)
+	ver.ID = 1
+	ver.Source = "001_test.sql"
+	return internal.Lint(internal.Migration{*ver}, cfg)
+}
+
+func rules(findings []internal.Finding) []string {
+	ids := make([]string, 0, len(findings))
+	for _, f := range findings {
+		ids = append(ids, f.Rule)
+	}
+	return ids
+}
+
+func TestLint(t *testing.T) {
+	t.Run("testdata", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		as := assert.New(tt)
+		ms, err := internal.CollectMigration(os.DirFS("../testdata/migrations"))
+		reqrd.NoError(err)
+		findings := internal.Lint(ms, internal.LintConfig{})
+		as.Contains(findings, internal.Finding{
+			Rule:     "missing-rollback",
+			Severity: internal.SeverityWarning,
+			Source:   "002_add_new_field_norollback.sql",
+			Version:  2,
+			Line:     1,
+			Message:  "version has no rollback section, mark it `-- +mitch irreversible` if intended",
+		})
+		for _, f := range findings {
+			as.NotEqual("001_default_database.sql", f.Source, f.String())
+		}
+	})
+
+	t.Run("invalid file", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		as := assert.New(tt)
+		dir := fstest.MapFS{
+			"001_init.sql":   {Data: []byte(anotherUpSQL)},
+			"002_broken.sql": {Data: []byte("CREATE TABLE a (`Id` UInt8) ENGINE = Memory; DROP TABLE IF EXISTS b;\n")},
+		}
+		_, err := internal.CollectMigration(dir)
+		as.ErrorIs(err, mitch.ErrMultiStatementLine)
+
+		ms, err := internal.CollectLintable(dir)
+		reqrd.NoError(err)
+		reqrd.Len(ms, 2)
+		as.ErrorIs(ms[1].Err, mitch.ErrMultiStatementLine)
+
+		findings := internal.Lint(ms, internal.LintConfig{})
+		var invalid []internal.Finding
+		for _, f := range findings {
+			if f.Source == "002_broken.sql" {
+				invalid = append(invalid, f)
+			}
+		}
+		reqrd.Len(invalid, 1)
+		as.Equal("invalid-file", invalid[0].Rule)
+		as.Equal(internal.SeverityError, invalid[0].Severity)
+		as.Equal(int64(2), invalid[0].Version)
+	})
+
+	t.Run("statement rules", func(tt *testing.T) {
+		as := assert.New(tt)
+		content := "CREATE TABLE IF NOT EXISTS events (\n" +
+			"    `Id` UInt64 NOT NULL,\n" +
+			"    `Tenant` Nullable(String)\n" +
+			")\n" +
+			"ENGINE = MergeTree\n" +
+			"ORDER BY (Tenant, Id);\n" +
+			"ALTER TABLE events DELETE WHERE Id = 0;\n" +
+			"\n" +
+			"/* rollback\n" +
+			"DROP TABLE events;\n" +
+			"*/"
+		findings := lintFile(tt, content, internal.LintConfig{Cluster: true})
+		as.ElementsMatch([]string{
+			"missing-on-cluster",
+			"nullable-sorting-key",
+			"not-null-syntax",
+			"mutation-without-sync",
+			"drop-without-if-exists",
+			"non-idempotent",
+		}, rules(findings))
+		for _, f := range findings {
+			switch f.Rule {
+			case "mutation-without-sync":
+				as.Equal(7, f.Line)
+				as.Equal(2, f.Statement)
+			case "drop-without-if-exists":
+				as.Equal(10, f.Line)
+				as.Equal("down", f.Section)
+			}
+		}
+	})
+
+	t.Run("suppressed", func(tt *testing.T) {
+		as := assert.New(tt)
+		content := "-- +mitch nolint: missing-rollback\n" +
+			"\n" +
+			"-- +mitch nolint: drop-without-if-exists, non-idempotent\n" +
+			"DROP TABLE events;\n" +
+			"-- +mitch nolint\n" +
+			"INSERT INTO events VALUES (1);\n" +
+			"TRUNCATE TABLE logs;\n"
+		findings := lintFile(tt, content, internal.LintConfig{})
+		as.Equal([]string{"drop-without-if-exists"}, rules(findings))
+		as.Equal(7, findings[0].Line)
+	})
+
+	t.Run("irreversible", func(tt *testing.T) {
+		findings := lintFile(tt, "-- +mitch irreversible\n\n"+anotherUpSQL, internal.LintConfig{Disabled: []string{"non-idempotent"}})
+		assert.Empty(tt, findings)
+	})
+
+	t.Run("config", func(tt *testing.T) {
+		as := assert.New(tt)
+		cfg := internal.LintConfig{
+			Disabled:   []string{"non-idempotent"},
+			Severities: map[string]internal.Severity{"drop-without-if-exists": internal.SeverityError},
+		}
+		as.NoError(cfg.Validate())
+		findings := lintFile(tt, "DROP TABLE events;\n/* rollback\n*/", cfg)
+		as.Equal([]string{"missing-rollback", "drop-without-if-exists"}, rules(findings))
+		as.Equal(internal.SeverityError, findings[1].Severity)
+
+		as.Error(internal.LintConfig{Disabled: []string{"no-such-rule"}}.Validate())
+		as.Error(internal.LintConfig{Severities: map[string]internal.Severity{"non-idempotent": "fatal"}}.Validate())
+	})
+}
+
+func TestWriteSARIF(t *testing.T) {
+	reqrd := require.New(t)
+	as := assert.New(t)
+	buf := new(bytes.Buffer)
+	findings := []internal.Finding{{
+		Rule:     "missing-rollback",
+		Severity: internal.SeverityWarning,
+		Source:   "002_add_new_field_norollback.sql",
+		Line:     1,
+		Message:  "version has no rollback section",
+	}}
+	reqrd.NoError(internal.WriteSARIF(buf, findings, "migrations"))
+
+	var doc struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	reqrd.NoError(json.Unmarshal(buf.Bytes(), &doc))
+	as.Equal("2.1.0", doc.Version)
+	reqrd.Len(doc.Runs[0].Results, 1)
+	as.Equal("missing-rollback", doc.Runs[0].Results[0].RuleID)
+	as.Equal("migrations/002_add_new_field_norollback.sql", doc.Runs[0].Results[0].Locations[0].PhysicalLocation.ArtifactLocation.URI)
+}
@@ -5,9 +5,12 @@ import (
 	"database/sql"
 	"fmt"
 	"io/fs"
+	"math"
 	"sort"
 	"sync"
+	"time"
 
+	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/arhyth/mitch"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/sync/errgroup"
@@ -25,26 +28,94 @@ type Version struct {
 	ContentHash string
 	Up, Down    *SQL
 	Source      string
+	// Annotations are the file-level `-- +mitch` directives, those separated
+	// from the next statement by a blank line
+	Annotations Annotations
+	// Alternatives replace Up on servers matching their constraint
+	Alternatives []Alternative
+	// Err is why the file failed to parse, see `CollectLintable`
+	Err error
+}
+
+// IsBaseline reports whether the version squashes all versions up to its own,
+// see `Squash`
+func (v Version) IsBaseline() bool {
+	return v.Annotations.Has("baseline")
+}
+
+// IsIrreversible reports whether the version is marked `-- +mitch irreversible`
+func (v Version) IsIrreversible() bool {
+	return v.Annotations.Has("irreversible")
 }
 
 type SQL struct {
 	Statements []string
+	// Lines are the 1-based lines of the statements in their file
+	Lines []int
+	// Annotations are the `-- +mitch` directives directly above each statement
+	Annotations []Annotations
+}
+
+func (s *SQL) add(stmt string, line int, anns Annotations) {
+	s.Statements = append(s.Statements, stmt)
+	s.Lines = append(s.Lines, line)
+	s.Annotations = append(s.Annotations, anns)
+}
+
+// Line returns the line of the statement at idx, or 0 when unknown
+func (s *SQL) Line(idx int) int {
+	if idx < len(s.Lines) {
+		return s.Lines[idx]
+	}
+	return 0
+}
+
+// AnnotationsAt returns the directives of the statement at idx
+func (s *SQL) AnnotationsAt(idx int) Annotations {
+	if idx < len(s.Annotations) {
+		return s.Annotations[idx]
+	}
+	return nil
+}
+
+// StatementError is returned when a statement of a version fails
+type StatementError struct {
+	Version int64
+	Source  string
+	// Index is the 1-based position of the failed statement in its section
+	Index int
+	Err   error
+}
+
+func (e *StatementError) Error() string {
+	return fmt.Sprintf("failed to execute SQL: %s statement %d: %v", e.Source, e.Index, e.Err)
+}
+
+func (e *StatementError) Unwrap() error {
+	return e.Err
 }
 
 type Migration []Version
 
+// FillVersionAtIndex parses fname into ms[idx]. A file that fails to parse
+// leaves a version with only its ID, source and Err set.
 func (ms Migration) FillVersionAtIndex(idx int, dir fs.FS, fname string) error {
-	file, err := dir.Open(fname)
+	id, err := ParseVersion(fname)
 	if err != nil {
+		ms[idx] = Version{Source: fname, Err: err}
 		return err
 	}
-	ver, err := ParseMigration(file)
+	ms[idx] = Version{ID: id, Source: fname}
+
+	file, err := dir.Open(fname)
 	if err != nil {
+		ms[idx].Err = err
 		return err
 	}
-
-	id, err := ParseVersion(fname)
+	defer file.Close()
+	ver, err := ParseMigration(file)
 	if err != nil {
+		ms[idx].Err = err
 		return err
 	}
 	ver.ID = id
@@ -54,12 +125,71 @@ func (ms Migration) FillVersionAtIndex(idx int, dir fs.FS, fname string) error {
 }
 
 type Runner struct {
-	dbNameFunc func() string
-	dir        fs.FS
-	db         *sql.DB
+	dbNameFunc        func() string
+	serverVersionFunc func() (ServerVersion, error)
+	dir               fs.FS
+	db                *sql.DB
+	versionTable      string
+	versionDB         string
+	report            *Report
+	confirm           ConfirmFunc
+	// emptyRollback treats versions without a rollback section as reversible
+	emptyRollback bool
+	force         bool
+	// mutationTimeout bounds waiting for a statement's mutations, 0 waits
+	// until they finish
+	mutationTimeout time.Duration
+	// mutationFailGrace is how long a mutation may fail without progress
+	// before it fails the statement
+	mutationFailGrace time.Duration
+	pollInterval      time.Duration
+	preflight         *PreflightConfig
+	// querySettings and statementTimeout apply to statements without a
+	// directive of their own
+	querySettings    map[string]string
+	statementTimeout time.Duration
+	retry            RetryPolicy
+}
+
+// RunnerOption configures optional Runner behavior
+type RunnerOption func(*Runner)
+
+// WithVersionTable sets the name of the table used to track applied versions.
+// It defaults to `mitch.VersionTable`.
+func WithVersionTable(name string) RunnerOption {
+	return func(rr *Runner) {
+		if name != "" {
+			rr.versionTable = name
+		}
+	}
+}
+
+// WithVersionDB sets the database the version table lives in.
+// It defaults to the database of the connection.
+func WithVersionDB(name string) RunnerOption {
+	return func(rr *Runner) {
+		rr.versionDB = name
+	}
 }
 
-func NewRunner(dir fs.FS, db *sql.DB) *Runner {
+// WithEmptyRollback treats versions without a rollback section as reversible,
+// rolling them back only deletes their version row. By default they are
+// irreversible like versions marked `-- +mitch irreversible`.
+func WithEmptyRollback() RunnerOption {
+	return func(rr *Runner) {
+		rr.emptyRollback = true
+	}
+}
+
+// WithForceRollback lets `Rollback` roll back irreversible versions, running
+// whatever rollback section they have
+func WithForceRollback() RunnerOption {
+	return func(rr *Runner) {
+		rr.force = true
+	}
+}
+
+func NewRunner(dir fs.FS, db *sql.DB, opts ...RunnerOption) *Runner {
 	f := sync.OnceValue(func() string {
 		query := "SELECT currentDatabase();"
 		var dbName string
@@ -72,14 +202,41 @@ func NewRunner(dir fs.FS, db *sql.DB) *Runner {
 		return dbName
 	})
 
-	return &Runner{
-		dbNameFunc: f,
-		dir:        dir,
-		db:         db,
+	sv := sync.OnceValues(func() (ServerVersion, error) {
+		var version string
+		if err := db.QueryRow("SELECT version();").Scan(&version); err != nil {
+			return nil, fmt.Errorf("failed to query server version: %w", err)
+		}
+		return ParseServerVersion(version)
+	})
+
+	rr := &Runner{
+		dbNameFunc:        f,
+		serverVersionFunc: sv,
+		dir:               dir,
+		db:                db,
+		versionTable:      mitch.VersionTable,
+		pollInterval:      DefaultPollInterval,
+		mutationFailGrace: DefaultMutationFailGrace,
+	}
+	for _, opt := range opts {
+		opt(rr)
 	}
+	return rr
 }
 
 func (rr *Runner) Migrate(ctx context.Context) error {
+	return rr.MigrateTo(ctx, math.MaxInt64)
+}
+
+// MigrateTo applies unapplied versions up to and including target
+func (rr *Runner) MigrateTo(ctx context.Context, target int64) (err error) {
+	rr.report = NewReport(Up)
+	var current int64
+	defer func() {
+		rr.report.Finish(current, err)
+	}()
+
 	foundVersions, err := rr.CollectMigration()
 	if err != nil {
 		return err
@@ -92,14 +249,37 @@ func (rr *Runner) Migrate(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	if len(dbVersions) > 0 {
+		current = dbVersions[0].ID
+	}
+	rr.report.StartVersion = current
 
-	toApply, hasMissing := FindUnappliedVersions(dbVersions, foundVersions)
+	unapplied, hasMissing := FindUnappliedVersions(dbVersions, foundVersions)
 	if hasMissing {
 		log.Warn().Msg("Migration have missing versions")
+		rr.report.Warn("migration has missing versions lower than current version %d", current)
+	}
+	toApply, err := pendingUpTo(unapplied, current, target)
+	if err != nil {
+		return err
+	}
+	for _, ver := range toApply {
+		// fail before applying anything rather than part way through
+		if err := rr.checkServer(ver); err != nil {
+			return err
+		}
+	}
+	if rr.preflight != nil && len(toApply) > 0 {
+		rr.report.Preflight, err = rr.Preflight(ctx, toApply)
+		if err != nil {
+			return err
+		}
+	}
+	if err := rr.guardAll(ctx, toApply, Up); err != nil {
+		return err
 	}
-	var current int64
 	for _, ver := range toApply {
-		if err := rr.Run(ctx, ver, Up); err != nil {
+		if err := rr.run(ctx, ver, Up, true); err != nil {
 			return err
 		}
 		current = ver.ID
@@ -120,7 +300,34 @@ func (rr *Runner) Migrate(ctx context.Context) error {
 	return nil
 }
 
-func (rr *Runner) Rollback(ctx context.Context, fname string) error {
+// pendingUpTo returns the unapplied versions, in ascending order, up to and
+// including target for a database at version current
+func pendingUpTo(unapplied Migration, current, target int64) (Migration, error) {
+	var pending Migration
+	for _, ver := range unapplied {
+		if ver.ID > target {
+			break
+		}
+		if ver.IsBaseline() && current > 0 {
+			// a baseline recreates the schema from scratch, it cannot be
+			// applied on top of a database part way through the squashed versions
+			return nil, fmt.Errorf(
+				"%w: database is at version %d, baseline %s squashes versions up to %d",
+				mitch.ErrBaselineGap, current, ver.Source, ver.ID,
+			)
+		}
+		pending = append(pending, ver)
+	}
+	return pending, nil
+}
+
+func (rr *Runner) Rollback(ctx context.Context, fname string) (err error) {
+	rr.report = NewReport(Down)
+	var endVersion int64
+	defer func() {
+		rr.report.Finish(endVersion, err)
+	}()
+
 	migrations, err := rr.CollectMigration()
 	if err != nil {
 		return err
@@ -137,6 +344,10 @@ func (rr *Runner) Rollback(ctx context.Context, fname string) error {
 	if err != nil {
 		return err
 	}
+	if len(appliedVers) > 0 {
+		endVersion = appliedVers[0].ID
+	}
+	rr.report.StartVersion = endVersion
 
 	var targetId int64
 	idx := len(appliedVers) - 1
@@ -149,7 +360,23 @@ func (rr *Runner) Rollback(ctx context.Context, fname string) error {
 		idx -= 1
 	}
 
-	for _, current := range appliedVers {
+	if err := rr.checkReversible(appliedVers, inFSVers, targetId); err != nil {
+		return err
+	}
+	var toRollback Migration
+	for _, applied := range appliedVers {
+		if applied.ID < targetId || applied.ID == 0 {
+			break
+		}
+		if found, exist := inFSVers[applied.ContentHash]; exist && found.ID == applied.ID {
+			toRollback = append(toRollback, found)
+		}
+	}
+	if err := rr.guardAll(ctx, toRollback, Down); err != nil {
+		return err
+	}
+
+	for i, current := range appliedVers {
 		if current.ID == 0 {
 			log.Info().Msgf("mitch: no migrations to run. current version: %d\n", current.ID)
 			break
@@ -165,6 +392,10 @@ func (rr *Runner) Rollback(ctx context.Context, fname string) error {
 			log.Warn().
 				Int64("version", current.ID).
 				Msg("Applied version missing in filesystem migrations")
+			rr.report.Warn("applied version %d missing in filesystem migrations", current.ID)
+			// without the file, there is no rollback to run, eg. a version
+			// superseded by a baseline
+			return fmt.Errorf("%w: version %d", mitch.ErrMissingVersionFile, current.ID)
 		}
 		if exist && found.ID != current.ID {
 			log.Error().
@@ -174,9 +405,12 @@ func (rr *Runner) Rollback(ctx context.Context, fname string) error {
 			return mitch.ErrVersionDiscrepancy
 		}
 
-		if err = rr.Run(ctx, found, Down); err != nil {
+		if err = rr.run(ctx, found, Down, true); err != nil {
 			return err
 		}
+		if i+1 < len(appliedVers) {
+			endVersion = appliedVers[i+1].ID
+		}
 
 		if found.ID == targetId {
 			log.Info().Msgf("mitch: successfully rolled database back to version: %d\n", targetId-1)
@@ -186,34 +420,130 @@ func (rr *Runner) Rollback(ctx context.Context, fname string) error {
 	return nil
 }
 
+// irreversible reports whether ver cannot be rolled back under the runner policy
+func (rr *Runner) irreversible(ver Version) bool {
+	if ver.IsIrreversible() {
+		return true
+	}
+	return !rr.emptyRollback && (ver.Down == nil || len(ver.Down.Statements) == 0)
+}
+
+// checkReversible fails before anything is rolled back when an applied version
+// down to targetId is irreversible, unless forced
+func (rr *Runner) checkReversible(appliedVers []Version, inFSVers map[string]Version, targetId int64) error {
+	for _, applied := range appliedVers {
+		if applied.ID < targetId || applied.ID == 0 {
+			break
+		}
+		found, exist := inFSVers[applied.ContentHash]
+		if !exist || !rr.irreversible(found) {
+			continue
+		}
+		if rr.force {
+			log.Warn().
+				Int64("version", found.ID).
+				Str("source", found.Source).
+				Msg("Rolling back irreversible version")
+			rr.report.Warn("rolled back irreversible version %d", found.ID)
+			continue
+		}
+		return fmt.Errorf(
+			"%w: %s cannot be rolled back, nothing was rolled back (force to roll back anyway)",
+			mitch.ErrIrreversible, found.Source,
+		)
+	}
+	return nil
+}
+
+// Run applies or rolls back a single version, asking the destructive guard
+// about it first
 func (rr *Runner) Run(ctx context.Context, ver Version, direction MigrationDirection) error {
-	tx, err := rr.db.BeginTx(ctx, nil)
+	return rr.run(ctx, ver, direction, false)
+}
+
+// section returns the statements ver runs in direction on the connected server
+func (rr *Runner) section(ver Version, direction MigrationDirection) (*SQL, error) {
+	section := ver.Down
+	if direction == Up {
+		var err error
+		if section, err = rr.upFor(ver); err != nil {
+			return nil, err
+		}
+	}
+	if section == nil {
+		section = &SQL{}
+	}
+	return section, nil
+}
+
+// run is `Run`, with guarded set when the caller already ran the destructive
+// guard over ver, see `guardAll`
+func (rr *Runner) run(ctx context.Context, ver Version, direction MigrationDirection, guarded bool) (err error) {
+	vr := VersionReport{
+		ID:         ver.ID,
+		Source:     ver.Source,
+		Direction:  direction.String(),
+		Statements: []StatementReport{},
+	}
+	start := time.Now()
+	defer func() {
+		vr.DurationMS = millis(time.Since(start))
+		if err != nil {
+			vr.Error = err.Error()
+		}
+		rr.report.AddVersion(vr)
+	}()
+
+	if err := rr.checkServer(ver); err != nil {
+		return err
+	}
+	section, err := rr.section(ver, direction)
+	if err != nil {
+		return err
+	}
+	spec, batched, err := ver.BatchSpec()
+	if err != nil {
+		return err
+	}
+	// only the up section of a data migration is batched
+	batched = batched && direction == Up
+	if !guarded {
+		if err := rr.guard(ctx, ver, direction, section.Statements); err != nil {
+			return err
+		}
+	}
+
+	tx, err := rr.beginRunTx(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
-	var stmts []string
-	if direction == Up {
-		stmts = ver.Up.Statements
+	if batched {
+		err = rr.runBatches(ctx, tx, ver, spec, section, &vr)
 	} else {
-		stmts = ver.Down.Statements
+		err = rr.runStatements(ctx, tx, ver, section, &vr)
 	}
-
-	for _, st := range stmts {
-		if _, err := tx.ExecContext(ctx, st); err != nil {
-			_ = tx.Rollback()
-			return fmt.Errorf("failed to execute SQL: %w", err)
-		}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
 	}
 
 	if direction == Up {
-		if err := rr.InsertVersion(ctx, tx, ver); err != nil {
+		op := fmt.Sprintf("insert version %d", ver.ID)
+		recorded := func() (bool, error) {
+			q := fmt.Sprintf(`SELECT count() FROM %s WHERE version_id = $1;`, rr.VersionTable())
+			return rr.anyRows(ctx, q, ver.ID)
+		}
+		err := rr.insertOnce(ctx, op, tx.renew, recorded, func() error {
+			return rr.InsertVersion(ctx, tx.Tx, ver)
+		})
+		if err != nil {
 			log.Info().Msg("Rollback transaction")
 			_ = tx.Rollback()
 			return fmt.Errorf("failed to insert new version: %w", err)
 		}
 	} else {
-		if err := rr.DeleteVersion(ctx, tx, ver); err != nil {
+		if err := rr.DeleteVersion(ctx, tx.Tx, ver); err != nil {
 			log.Info().Msg("Rollback transaction")
 			_ = tx.Rollback()
 			return fmt.Errorf("failed to delete version: %w", err)
@@ -223,14 +553,106 @@ func (rr *Runner) Run(ctx context.Context, ver Version, direction MigrationDirec
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	if batched {
+		if err := rr.clearBatches(ctx, ver); err != nil {
+			// stale checkpoints only matter when the version is applied again
+			log.Warn().
+				Err(err).
+				Str("source", ver.Source).
+				Msg("failed to clear batch checkpoints")
+		}
+	}
 	return nil
 }
 
+// runStatements runs the statements of section in order, stopping at the first
+// failure
+func (rr *Runner) runStatements(ctx context.Context, tx *runTx, ver Version, section *SQL, vr *VersionReport) error {
+	for idx := range section.Statements {
+		sr, err := rr.exec(ctx, tx, ver, section, idx)
+		vr.Statements = append(vr.Statements, sr)
+		if err != nil {
+			vr.FailedStatement = sr.Index
+			return &StatementError{
+				Version: ver.ID,
+				Source:  ver.Source,
+				Index:   sr.Index,
+				Err:     err,
+			}
+		}
+	}
+	return nil
+}
+
+// exec runs the statement at idx of section and reports its timing and, where
+// the server sends write progress, the number of rows written. Statements
+// creating mutations only finish when their mutations do, see `waitMutations`.
+// Idempotent statements are retried on transient failures, see `WithRetry`.
+func (rr *Runner) exec(ctx context.Context, tx *runTx, ver Version, section *SQL, idx int) (StatementReport, error) {
+	fileAnns := ver.Annotations
+	stmt := section.Statements[idx]
+	sr := StatementReport{Index: idx + 1, Class: Classify(stmt).String()}
+	tracker, err := rr.trackMutations(ctx, stmt, section.AnnotationsAt(idx), fileAnns)
+	if err != nil {
+		sr.Error = err.Error()
+		return sr, err
+	}
+	var (
+		wrote      uint64
+		progressed bool
+	)
+	settings, timeout, err := rr.StatementSettings(ver, section, idx)
+	if err != nil {
+		sr.Error = err.Error()
+		return sr, err
+	}
+	stmtCtx, cancel := statementContext(ctx, timeout)
+	defer cancel()
+	stmtCtx = clickhouse.Context(stmtCtx,
+		clickhouse.WithSettings(settings),
+		clickhouse.WithProgress(func(p *clickhouse.Progress) {
+			progressed = true
+			wrote += p.WroteRows
+		}),
+	)
+
+	run := func() error {
+		_, err := tx.ExecContext(stmtCtx, stmt)
+		if err != nil {
+			return timeoutErr(stmtCtx, timeout, err)
+		}
+		return nil
+	}
+	start := time.Now()
+	if IsIdempotent(stmt) {
+		op := fmt.Sprintf("%s statement %d", ver.Source, idx+1)
+		sr.Retries, err = rr.withRetry(ctx, op, tx.renew, run)
+	} else {
+		err = run()
+	}
+	if err == nil && tracker != nil {
+		sr.Mutations, err = tracker.wait(ctx)
+	}
+	sr.DurationMS = millis(time.Since(start))
+	if progressed {
+		sr.RowsAffected = &wrote
+	}
+	if err != nil {
+		sr.Error = err.Error()
+	}
+	return sr, err
+}
+
+// Report returns the report of the last `Migrate` or `Rollback` run
+func (rr *Runner) Report() *Report {
+	return rr.report
+}
+
 func (rr *Runner) InsertVersion(ctx context.Context, tx *sql.Tx, ver Version) error {
-	q := `INSERT INTO %s.%s (version_id, source, content_hash) VALUES ($1, $2, $3);`
+	q := `INSERT INTO %s (version_id, source, content_hash) VALUES ($1, $2, $3);`
 	_, err := tx.ExecContext(
 		ctx,
-		fmt.Sprintf(q, rr.GetDBName(), mitch.VersionTable),
+		fmt.Sprintf(q, rr.VersionTable()),
 		ver.ID,
 		ver.Source,
 		ver.ContentHash,
@@ -239,28 +661,26 @@ func (rr *Runner) InsertVersion(ctx context.Context, tx *sql.Tx, ver Version) er
 }
 
 func (rr *Runner) DeleteVersion(ctx context.Context, tx *sql.Tx, ver Version) error {
-	q := `ALTER TABLE %s.%s DELETE WHERE version_id = $1 SETTINGS mutations_sync = 2;`
+	q := `ALTER TABLE %s DELETE WHERE version_id = $1 SETTINGS mutations_sync = 2;`
 	_, err := tx.ExecContext(
 		ctx,
-		fmt.Sprintf(q, rr.GetDBName(), mitch.VersionTable),
+		fmt.Sprintf(q, rr.VersionTable()),
 		ver.ID,
 	)
 	return err
 }
 
 func (rr *Runner) MustVersionTable(ctx context.Context) error {
-	var query = fmt.Sprintf(`
-		CREATE TABLE IF NOT EXISTS %s.%s (
-		    version_id Int64,
-		    source String,
-			content_hash FixedString(64),
-		    created_at DateTime default now()
-		)
-		ENGINE = MergeTree()
-		PRIMARY KEY version_id
-		ORDER BY (version_id, content_hash);
-	`, rr.GetDBName(), mitch.VersionTable)
-	_, err := rr.db.ExecContext(ctx, query)
+	if rr.versionDB != "" {
+		// a dedicated metadata database may be shared by several migration sets
+		// and is not guaranteed to exist like the connection database is
+		q := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s;", rr.versionDB)
+		if _, err := rr.db.ExecContext(ctx, q); err != nil {
+			return err
+		}
+	}
+
+	_, err := rr.db.ExecContext(ctx, versionTableDDL(rr.VersionTable()))
 	if err != nil {
 		return err
 	}
@@ -282,12 +702,56 @@ func (rr *Runner) MustVersionTable(ctx context.Context) error {
 	return nil
 }
 
+// versionTableDDL returns the statement creating the version table if it does not exist
+func versionTableDDL(table string) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    version_id Int64,
+    source String,
+    content_hash FixedString(64),
+    created_at DateTime default now()
+)
+ENGINE = MergeTree()
+PRIMARY KEY version_id
+ORDER BY (version_id, content_hash);`, table)
+}
+
 func (rr *Runner) GetDBName() string {
 	return rr.dbNameFunc()
 }
 
+// VersionTable returns the fully qualified `database.table` name of the version table
+func (rr *Runner) VersionTable() string {
+	dbName := rr.versionDB
+	if dbName == "" {
+		dbName = rr.GetDBName()
+	}
+	return fmt.Sprintf("%s.%s", dbName, rr.versionTable)
+}
+
 func (rr *Runner) CollectMigration() (Migration, error) {
-	sqlMs, err := fs.Glob(rr.dir, "*.sql")
+	return CollectMigration(rr.dir)
+}
+
+// CollectMigration parses the migration files in dir, ordered by file name.
+// It fails on the first file that cannot be parsed.
+func CollectMigration(dir fs.FS) (Migration, error) {
+	migrations, err := CollectLintable(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, ver := range migrations {
+		if ver.Err != nil {
+			return nil, fmt.Errorf("%s: %w", ver.Source, ver.Err)
+		}
+	}
+	return migrations, nil
+}
+
+// CollectLintable parses the migration files in dir like `CollectMigration`,
+// but files that fail to parse do not fail collection: their versions carry
+// the error in `Version.Err` for `Lint` to report
+func CollectLintable(dir fs.FS) (Migration, error) {
+	sqlMs, err := fs.Glob(dir, "*.sql")
 	if err != nil {
 		return nil, err
 	}
@@ -297,7 +761,7 @@ func (rr *Runner) CollectMigration() (Migration, error) {
 	errgp := new(errgroup.Group)
 	for idx, fname := range sqlMs {
 		errgp.Go(func() error {
-			migrations.FillVersionAtIndex(idx, rr.dir, fname)
+			_ = migrations.FillVersionAtIndex(idx, dir, fname)
 			return nil
 		})
 	}
@@ -312,23 +776,27 @@ func (rr *Runner) CollectMigration() (Migration, error) {
 // Caution: DO NOT change the sort order without examining current use of this method
 // in the codebase. Some may be relying on this behavior.
 func (rr *Runner) ListDBVersions(ctx context.Context) ([]Version, error) {
-	q := `SELECT version_id, content_hash, source FROM %s.%s ORDER BY version_id DESC;`
-
-	rows, err := rr.db.QueryContext(ctx, fmt.Sprintf(q, rr.GetDBName(), mitch.VersionTable))
-	if err != nil {
-		return nil, fmt.Errorf("failed to list migrations: %w", err)
-	}
-	defer rows.Close()
+	q := `SELECT version_id, content_hash, source FROM %s ORDER BY version_id DESC;`
 
 	var versions []Version
-	for rows.Next() {
-		var ver Version
-		if err := rows.Scan(&ver.ID, &ver.ContentHash, &ver.Source); err != nil {
-			return nil, fmt.Errorf("failed to scan list migrations result: %w", err)
+	_, err := rr.withRetry(ctx, "list versions", nil, func() error {
+		rows, err := rr.db.QueryContext(ctx, fmt.Sprintf(q, rr.VersionTable()))
+		if err != nil {
+			return fmt.Errorf("failed to list migrations: %w", err)
 		}
-		versions = append(versions, ver)
-	}
-	if err := rows.Err(); err != nil {
+		defer rows.Close()
+
+		versions = versions[:0]
+		for rows.Next() {
+			var ver Version
+			if err := rows.Scan(&ver.ID, &ver.ContentHash, &ver.Source); err != nil {
+				return fmt.Errorf("failed to scan list migrations result: %w", err)
+			}
+			versions = append(versions, ver)
+		}
+		return rows.Err()
+	})
+	if err != nil {
 		return nil, err
 	}
 	return versions, nil
@@ -337,12 +805,12 @@ func (rr *Runner) ListDBVersions(ctx context.Context) ([]Version, error) {
 func (rr *Runner) GetCurrentVersion(ctx context.Context) (*Version, error) {
 	q := `
 		SELECT version_id, content_hash, source
-		FROM %s.%s
+		FROM %s
 		ORDER BY version_id DESC
 		LIMIT 1;
 	`
 
-	row := rr.db.QueryRowContext(ctx, fmt.Sprintf(q, rr.GetDBName(), mitch.VersionTable))
+	row := rr.db.QueryRowContext(ctx, fmt.Sprintf(q, rr.VersionTable()))
 
 	var ver Version
 	if err := row.Scan(&ver.ID, &ver.ContentHash, &ver.Source); err != nil {
@@ -354,7 +822,8 @@ func (rr *Runner) GetCurrentVersion(ctx context.Context) (*Version, error) {
 
 // FindUnappliedVersions collects versions in the filesystem that has not been applied to the database.
 // It does not include missing versions that are versions lower than current version in the database,
-// only indicating missing versions by returning a boolean
+// only indicating missing versions by returning a boolean.
+// A baseline is considered applied when the database is at or past its version.
 func FindUnappliedVersions(dbVersions, fsVersions Migration) (unapplied Migration, hasMissing bool) {
 	appliedVers := make(map[string]int64)
 	for _, ver := range dbVersions {
@@ -373,6 +842,10 @@ func FindUnappliedVersions(dbVersions, fsVersions Migration) (unapplied Migratio
 		if applied {
 			continue
 		}
+		if found.IsBaseline() && found.ID <= dbLatest {
+			// the database applied the squashed versions themselves
+			continue
+		}
 		if !applied && found.ID < dbLatest {
 			hasMissing = true
 			continue
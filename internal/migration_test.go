@@ -1,8 +1,11 @@
 package internal_test
 
 import (
+	"context"
 	"testing"
+	"testing/fstest"
 
+	"github.com/arhyth/mitch"
 	"github.com/arhyth/mitch/internal"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -35,3 +38,61 @@ func TestFindUnappliedVersions(t *testing.T) {
 	as.Equal(1, len(unapplied))
 	as.Equal(inFS[7].ID, unapplied[0].ID)
 }
+
+func TestFindUnappliedVersionsBaseline(t *testing.T) {
+	inFS := []internal.Version{
+		{ID: 120, ContentHash: "baseline", Annotations: internal.Annotations{{Key: "baseline"}}},
+		{ID: 121, ContentHash: "next"},
+	}
+
+	t.Run("new database", func(tt *testing.T) {
+		as := assert.New(tt)
+		unapplied, hasMissing := internal.FindUnappliedVersions(nil, inFS)
+		as.False(hasMissing)
+		as.Len(unapplied, 2)
+	})
+
+	t.Run("squashed versions applied", func(tt *testing.T) {
+		as := assert.New(tt)
+		inDB := []internal.Version{
+			{ID: 119, ContentHash: "old"},
+			{ID: 120, ContentHash: "squashed"},
+		}
+		unapplied, hasMissing := internal.FindUnappliedVersions(inDB, inFS)
+		as.False(hasMissing)
+		as.Len(unapplied, 1)
+		as.Equal(int64(121), unapplied[0].ID)
+	})
+}
+
+func TestVersionTable(t *testing.T) {
+	as := assert.New(t)
+	runner := internal.NewRunner(nil, nil, internal.WithVersionDB("meta"))
+	as.Equal("meta."+mitch.VersionTable, runner.VersionTable())
+
+	runner = internal.NewRunner(nil, nil, internal.WithVersionDB("meta"), internal.WithVersionTable("versions"))
+	as.Equal("meta.versions", runner.VersionTable())
+
+	runner = internal.NewRunner(nil, nil, internal.WithVersionDB("meta"), internal.WithVersionTable(""))
+	as.Equal("meta."+mitch.VersionTable, runner.VersionTable())
+}
+
+func TestInvalidFile(t *testing.T) {
+	dir := fstest.MapFS{
+		"001_init.sql":   {Data: []byte(sampleUpSQL)},
+		"002_broken.sql": {Data: []byte("CREATE TABLE a (`Id` UInt8) ENGINE = Memory; DROP TABLE IF EXISTS b;\n")},
+	}
+	runner := internal.NewRunner(dir, nil)
+
+	t.Run("migrate", func(tt *testing.T) {
+		err := runner.Migrate(context.Background())
+		assert.ErrorIs(tt, err, mitch.ErrMultiStatementLine)
+		assert.ErrorContains(tt, err, "002_broken.sql")
+	})
+
+	t.Run("mark applied", func(tt *testing.T) {
+		marked, err := runner.MarkApplied(context.Background(), 2)
+		assert.ErrorIs(tt, err, mitch.ErrMultiStatementLine)
+		assert.Empty(tt, marked)
+	})
+}
@@ -0,0 +1,250 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/arhyth/mitch"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultPollInterval is how often system.mutations is polled while waiting
+// for a statement's mutations
+const DefaultPollInterval = time.Second
+
+// DefaultMutationFailGrace is how long a mutation may fail without progress
+// before it fails the statement. ClickHouse retries failed mutations, and a
+// transient failure, eg. a memory limit, may clear on the next attempt.
+const DefaultMutationFailGrace = time.Minute
+
+// WithMutationTimeout bounds how long a statement waits for its mutations, by
+// default it waits until they finish or fail. A statement overrides it with
+// a `-- +mitch mutation-timeout: <duration>` directive, as can a whole file.
+func WithMutationTimeout(timeout time.Duration) RunnerOption {
+	return func(rr *Runner) {
+		rr.mutationTimeout = timeout
+	}
+}
+
+// WithMutationFailGrace sets how long a mutation may report a
+// `latest_fail_reason` without its parts progressing before it fails the
+// statement. It defaults to `DefaultMutationFailGrace`, 0 fails on the first
+// failure reason.
+func WithMutationFailGrace(grace time.Duration) RunnerOption {
+	return func(rr *Runner) {
+		if grace >= 0 {
+			rr.mutationFailGrace = grace
+		}
+	}
+}
+
+// WithPollInterval sets how often the runner polls system tables while waiting
+func WithPollInterval(interval time.Duration) RunnerOption {
+	return func(rr *Runner) {
+		if interval > 0 {
+			rr.pollInterval = interval
+		}
+	}
+}
+
+// mutationTracker waits for the mutations a statement creates on its table
+type mutationTracker struct {
+	rr       *Runner
+	database string
+	table    string
+	timeout  time.Duration
+	// known are the mutations of the table before the statement ran
+	known map[string]bool
+	// poll returns the mutations of the table, see `states`
+	poll func(context.Context) ([]mutationState, error)
+}
+
+type mutationState struct {
+	id         string
+	done       bool
+	partsToDo  int64
+	failReason string
+}
+
+// trackMutations prepares waiting for the mutations of stmt, it returns nil
+// for statements that do not create mutations
+func (rr *Runner) trackMutations(ctx context.Context, stmt string, stmtAnns, fileAnns Annotations) (*mutationTracker, error) {
+	if !CreatesMutation(stmt) {
+		return nil, nil
+	}
+	database, table := AffectedTable(stmt)
+	if table == "" {
+		log.Debug().
+			Str("statement", firstLine(stmt)).
+			Msg("Cannot tell the table of mutation, not waiting for it")
+		return nil, nil
+	}
+	if database == "" {
+		database = rr.GetDBName()
+	}
+
+	timeout, err := annotatedDuration("mutation-timeout", rr.mutationTimeout, stmtAnns, fileAnns)
+	if err != nil {
+		return nil, err
+	}
+	mt := &mutationTracker{
+		rr:       rr,
+		database: database,
+		table:    table,
+		timeout:  timeout,
+		known:    make(map[string]bool),
+	}
+	mt.poll = mt.states
+	states, err := mt.poll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range states {
+		mt.known[st.id] = true
+	}
+	return mt, nil
+}
+
+// stall is since when a failing mutation has not progressed
+type stall struct {
+	since     time.Time
+	partsToDo int64
+}
+
+// wait polls system.mutations until the mutations created since tracking
+// started are done. It fails with the latest failure reason of a mutation
+// that did not progress for the fail grace of the runner, or when the timeout
+// passes. It returns the IDs of the created mutations.
+func (mt *mutationTracker) wait(ctx context.Context) ([]string, error) {
+	if mt.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, mt.timeout)
+		defer cancel()
+	}
+	ticker := time.NewTicker(mt.rr.pollInterval)
+	defer ticker.Stop()
+
+	var (
+		ids       []string
+		lastParts int64 = -1
+		stalled         = make(map[string]stall)
+	)
+	for {
+		states, err := mt.poll(ctx)
+		if err != nil {
+			return ids, mt.ctxErr(ctx, lastParts, err)
+		}
+
+		ids = ids[:0]
+		var (
+			pending   bool
+			partsToDo int64
+		)
+		for _, st := range states {
+			if mt.known[st.id] {
+				continue
+			}
+			ids = append(ids, st.id)
+			if st.failReason == "" || st.done {
+				delete(stalled, st.id)
+			} else {
+				s, ok := stalled[st.id]
+				if !ok || st.partsToDo < s.partsToDo {
+					s = stall{since: time.Now(), partsToDo: st.partsToDo}
+					stalled[st.id] = s
+				}
+				if time.Since(s.since) >= mt.rr.mutationFailGrace {
+					return ids, fmt.Errorf(
+						"%w: %s on %s.%s: %s",
+						mitch.ErrMutationFailed, st.id, mt.database, mt.table, st.failReason,
+					)
+				}
+				if !ok {
+					log.Warn().
+						Str("table", mt.database+"."+mt.table).
+						Str("mutation", st.id).
+						Str("reason", st.failReason).
+						Msg("mitch: mutation failed, waiting for ClickHouse to retry it")
+				}
+			}
+			if !st.done {
+				pending = true
+				partsToDo += st.partsToDo
+			}
+		}
+		if !pending {
+			return ids, nil
+		}
+		if partsToDo != lastParts {
+			log.Info().
+				Str("table", mt.database+"."+mt.table).
+				Strs("mutations", ids).
+				Int64("parts_remaining", partsToDo).
+				Msg("mitch: waiting for mutation")
+			lastParts = partsToDo
+		}
+
+		select {
+		case <-ctx.Done():
+			return ids, mt.ctxErr(ctx, lastParts, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// ctxErr reports a passed timeout as ErrMutationTimeout, other errors as is
+func (mt *mutationTracker) ctxErr(ctx context.Context, partsToDo int64, err error) error {
+	if mt.timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf(
+			"%w: %s.%s after %s, %d parts remaining",
+			mitch.ErrMutationTimeout, mt.database, mt.table, mt.timeout, partsToDo,
+		)
+	}
+	return err
+}
+
+func (mt *mutationTracker) states(ctx context.Context) ([]mutationState, error) {
+	q := `
+		SELECT mutation_id, is_done, parts_to_do, latest_fail_reason
+		FROM system.mutations
+		WHERE database = $1 AND table = $2;
+	`
+	rows, err := mt.rr.db.QueryContext(ctx, q, mt.database, mt.table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query mutations: %w", err)
+	}
+	defer rows.Close()
+
+	var states []mutationState
+	for rows.Next() {
+		var (
+			st   mutationState
+			done uint8
+		)
+		if err := rows.Scan(&st.id, &done, &st.partsToDo, &st.failReason); err != nil {
+			return nil, fmt.Errorf("failed to scan mutations: %w", err)
+		}
+		st.done = done == 1
+		states = append(states, st)
+	}
+	return states, rows.Err()
+}
+
+// annotatedDuration returns the duration of the key directive of a statement,
+// falling back to the file directive and then to def
+func annotatedDuration(key string, def time.Duration, stmtAnns, fileAnns Annotations) (time.Duration, error) {
+	for _, anns := range []Annotations{stmtAnns, fileAnns} {
+		v, ok := anns.Get(key)
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s: %v", mitch.ErrInvalidAnnotation, key, err)
+		}
+		return d, nil
+	}
+	return def, nil
+}
@@ -0,0 +1,61 @@
+package internal_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/arhyth/mitch"
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitMutations(t *testing.T) {
+	newRunner := func(grace time.Duration) *internal.Runner {
+		return internal.NewRunner(nil, nil,
+			internal.WithPollInterval(time.Millisecond),
+			internal.WithMutationFailGrace(grace),
+		)
+	}
+
+	t.Run("done", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		ids, err := internal.WaitMutations(context.Background(), newRunner(time.Hour), 0,
+			[]internal.MutationRow{{ID: "mutation_1.txt", PartsToDo: 2}},
+			[]internal.MutationRow{{ID: "mutation_1.txt", PartsToDo: 1}},
+			[]internal.MutationRow{{ID: "mutation_1.txt", Done: true}},
+		)
+		reqrd.NoError(err)
+		reqrd.Equal([]string{"mutation_1.txt"}, ids)
+	})
+
+	t.Run("transient failure", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		ids, err := internal.WaitMutations(context.Background(), newRunner(time.Hour), 0,
+			[]internal.MutationRow{{ID: "mutation_1.txt", PartsToDo: 2, FailReason: "Memory limit exceeded"}},
+			[]internal.MutationRow{{ID: "mutation_1.txt", PartsToDo: 1, FailReason: "Memory limit exceeded"}},
+			[]internal.MutationRow{{ID: "mutation_1.txt", Done: true}},
+		)
+		reqrd.NoError(err)
+		reqrd.Equal([]string{"mutation_1.txt"}, ids)
+	})
+
+	t.Run("failure", func(tt *testing.T) {
+		as := assert.New(tt)
+		_, err := internal.WaitMutations(context.Background(), newRunner(10*time.Millisecond), time.Minute,
+			[]internal.MutationRow{{ID: "mutation_1.txt", PartsToDo: 2, FailReason: "Cannot parse input"}},
+		)
+		as.ErrorIs(err, mitch.ErrMutationFailed)
+		as.ErrorContains(err, "Cannot parse input")
+	})
+
+	t.Run("timeout", func(tt *testing.T) {
+		as := assert.New(tt)
+		_, err := internal.WaitMutations(context.Background(), newRunner(time.Hour), 20*time.Millisecond,
+			[]internal.MutationRow{{ID: "mutation_1.txt", PartsToDo: 2}},
+		)
+		as.ErrorIs(err, mitch.ErrMutationTimeout)
+		as.ErrorContains(err, "2 parts remaining")
+	})
+}
@@ -18,34 +18,78 @@ var (
 	rgxVerPrefix = regexp.MustCompile(`^[0-9]+`)
 )
 
+// alternativePrefix starts an up section for a range of server versions,
+// eg. `/* up clickhouse: <24.3`, closed by `*/`
+const alternativePrefix = "/* up clickhouse:"
+
 func ParseMigration(file io.Reader) (*Version, error) {
-	var forwardStmts, rollbackStmts []string
-	inRollback := false
+	var (
+		up, down SQL
+		alts     []Alternative
+	)
+	inRollback, inAlt := false, false
 
 	buf := new(bytes.Buffer)
 	tee := io.TeeReader(file, buf)
 	scanner := bufio.NewScanner(tee)
 	scanner.Split(SplitSQLStatements)
+	var annotations Annotations
+	// line is the 1-based line the current chunk starts on
+	line := 1
 	for scanner.Scan() {
-		line := scanner.Text()
-		line = strings.TrimSpace(line)
+		chunk := scanChunk(scanner.Text())
+		annotations = append(annotations, chunk.file...)
+		start := line + chunk.offset
+		line += strings.Count(scanner.Text(), "\n") + 1
+		stmt := strings.TrimSpace(chunk.text)
+		if stmt == "" {
+			continue
+		}
+
+		if inAlt && strings.HasPrefix(stmt, "*/") {
+			inAlt = false
+			rest := strings.TrimSpace(strings.TrimPrefix(stmt, "*/"))
+			start += strings.Count(stmt[:len(stmt)-len(rest)], "\n")
+			if stmt = rest; stmt == "" {
+				continue
+			}
+		}
+		if idx := strings.Index(stmt, alternativePrefix); idx != -1 && !inRollback {
+			inAlt = true
+			header, rest, _ := strings.Cut(stmt[idx:], "\n")
+			alts = append(alts, Alternative{
+				Constraint: strings.TrimSpace(strings.TrimPrefix(header, alternativePrefix)),
+				Up:         &SQL{},
+			})
+			if rest = strings.TrimSpace(rest); rest != "" {
+				alts[len(alts)-1].Up.add(rest, start+strings.Count(stmt[:idx], "\n")+1, chunk.stmt)
+			}
+			continue
+		}
+		if inAlt {
+			alts[len(alts)-1].Up.add(stmt, start, chunk.stmt)
+			continue
+		}
 
-		if strings.Contains(line, "/* rollback") {
+		if strings.Contains(stmt, "/* rollback") {
 			inRollback = true
-			spl := strings.Split(line, "/* rollback\n")
-			rollbackStmts = append(rollbackStmts, spl[1])
+			spl := strings.Split(stmt, "/* rollback\n")
+			if rest := strings.TrimSpace(spl[1]); rest != "" && rest != "*/" {
+				// an empty rollback section closes in the same chunk
+				down.add(spl[1], start+strings.Count(spl[0], "\n")+1, chunk.stmt)
+			}
 			continue
 		}
-		if inRollback && strings.HasSuffix(line, "*/") {
+		if inRollback && strings.HasSuffix(stmt, "*/") {
 			continue
 		}
 
 		if inRollback {
-			rollbackStmts = append(rollbackStmts, line)
+			down.add(stmt, start, chunk.stmt)
 			continue
 		}
 
-		forwardStmts = append(forwardStmts, line)
+		up.add(stmt, start, chunk.stmt)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -53,14 +97,65 @@ func ParseMigration(file io.Reader) (*Version, error) {
 	}
 
 	ver := &Version{
-		ContentHash: HashSum(buf.String()),
-		Up:          &SQL{Statements: forwardStmts},
-		Down:        &SQL{Statements: rollbackStmts},
+		ContentHash:  HashSum(buf.String()),
+		Up:           &up,
+		Down:         &down,
+		Annotations:  annotations,
+		Alternatives: alts,
 	}
 
 	return ver, nil
 }
 
+// chunk is a scanned piece of a migration file with its directives removed
+type chunk struct {
+	text string
+	// offset is the line of the first SQL line, relative to the chunk start
+	offset int
+	// file are directives separated from the statement by a blank line,
+	// stmt those directly above it
+	file, stmt Annotations
+}
+
+// scanChunk removes directive lines from a scanned chunk and sorts them into
+// file-level and statement-level directives
+func scanChunk(text string) chunk {
+	var (
+		ch      chunk
+		lines   []string
+		pending Annotations
+		found   bool
+	)
+	for i, line := range strings.Split(text, "\n") {
+		if ann, ok := ParseAnnotation(line); ok {
+			pending = append(pending, ann)
+			continue
+		}
+		lines = append(lines, line)
+
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			ch.file = append(ch.file, pending...)
+			pending = nil
+		case strings.HasPrefix(trimmed, "--"):
+			// comments between a directive and its statement are allowed
+			if !found {
+				ch.offset, found = i, true
+			}
+		default:
+			if !found {
+				ch.offset, found = i, true
+			}
+			ch.stmt = append(ch.stmt, pending...)
+			pending = nil
+		}
+	}
+	ch.file = append(ch.file, pending...)
+	ch.text = strings.Join(lines, "\n")
+	return ch
+}
+
 func HashSum(content ...string) string {
 	hash := sha256.New()
 	for _, c := range content {
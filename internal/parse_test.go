@@ -103,4 +103,28 @@ VALUES
 		as.Len(ver.Down.Statements, 3)
 		as.Equal("TRUNCATE TABLE users;", ver.Down.Statements[2])
 	})
+	t.Run("annotations", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		as := assert.New(tt)
+		rdr := strings.NewReader("-- +mitch baseline\n-- +mitch requires-clickhouse: >=24.3\n\n" + anotherUpSQL)
+		ver, err := internal.ParseMigration(rdr)
+		reqrd.Nil(err)
+		as.True(ver.IsBaseline())
+		req, ok := ver.Annotations.Get("requires-clickhouse")
+		as.True(ok)
+		as.Equal(">=24.3", req)
+		as.Equal([]string{anotherUpSQL}, ver.Up.Statements)
+	})
+	t.Run("statement annotations", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		as := assert.New(tt)
+		rdr := strings.NewReader("-- +mitch nolint\n\n" + sampleDownSQL + "\n-- +mitch nolint: non-idempotent\n-- adds a field\n" + anotherUpSQL)
+		ver, err := internal.ParseMigration(rdr)
+		reqrd.Nil(err)
+		as.Equal(internal.Annotations{{Key: "nolint"}}, ver.Annotations)
+		reqrd.Len(ver.Up.Statements, 2)
+		as.Empty(ver.Up.AnnotationsAt(0))
+		as.Equal(internal.Annotations{{Key: "nolint", Value: "non-idempotent"}}, ver.Up.AnnotationsAt(1))
+		as.Equal([]int{3, 5}, ver.Up.Lines)
+	})
 }
@@ -0,0 +1,439 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/arhyth/mitch"
+	"github.com/rs/zerolog/log"
+)
+
+// Preflight check names, see `PreflightConfig.Skip`
+const (
+	CheckMutations        = "mutations"
+	CheckReplicationQueue = "replication-queue"
+	CheckReadonlyReplicas = "readonly-replicas"
+	CheckDiskSpace        = "disk-space"
+	CheckGrants           = "grants"
+)
+
+// PreflightConfig tunes the checks `MigrateTo` runs before applying anything
+type PreflightConfig struct {
+	// MaxReplicationQueue is the largest replication queue of a table that passes
+	MaxReplicationQueue uint64
+	// MinFreeDiskRatio is the smallest free to total space ratio of a disk that passes
+	MinFreeDiskRatio float64
+	// Skip are names of checks that are not run
+	Skip []string
+}
+
+// DefaultPreflightConfig runs every check with conservative thresholds
+func DefaultPreflightConfig() PreflightConfig {
+	return PreflightConfig{
+		MaxReplicationQueue: 100,
+		MinFreeDiskRatio:    0.1,
+	}
+}
+
+// CheckResult is the outcome of a single preflight check
+type CheckResult struct {
+	Check string `json:"check"`
+	// Status is ok, failed, or error when the check itself could not run
+	Status   string   `json:"status"`
+	Problems []string `json:"problems,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// WithPreflight makes `MigrateTo` check that the cluster is in a safe state
+// before applying pending versions, failing without applying any otherwise
+func WithPreflight(cfg PreflightConfig) RunnerOption {
+	return func(rr *Runner) {
+		rr.preflight = &cfg
+	}
+}
+
+// Preflight runs the configured checks for the statements of vers, in the up
+// sections `Run` picks for the server. Checks that cannot run, eg. for lack of
+// access to a system table, are reported but do not fail the preflight.
+func (rr *Runner) Preflight(ctx context.Context, vers Migration) ([]CheckResult, error) {
+	cfg := DefaultPreflightConfig()
+	if rr.preflight != nil {
+		cfg = *rr.preflight
+	}
+	skip := make(map[string]bool, len(cfg.Skip))
+	for _, name := range cfg.Skip {
+		skip[name] = true
+	}
+
+	dbName := rr.GetDBName()
+	var stmts []string
+	for _, ver := range vers {
+		section, err := rr.section(ver, Up)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, section.Statements...)
+	}
+	tables := affectedTables(stmts, dbName)
+
+	checks := []struct {
+		name string
+		run  func() ([]string, error)
+	}{
+		{CheckMutations, func() ([]string, error) { return rr.pendingMutations(ctx, tables) }},
+		{CheckReplicationQueue, func() ([]string, error) { return rr.replicationBacklog(ctx, dbName, cfg.MaxReplicationQueue) }},
+		{CheckReadonlyReplicas, func() ([]string, error) { return rr.readonlyReplicas(ctx, dbName) }},
+		{CheckDiskSpace, func() ([]string, error) { return rr.lowDiskSpace(ctx, cfg.MinFreeDiskRatio) }},
+		{CheckGrants, func() ([]string, error) { return rr.missingGrants(ctx, stmts, dbName) }},
+	}
+
+	var (
+		results []CheckResult
+		failed  []string
+	)
+	for _, check := range checks {
+		if skip[check.name] {
+			continue
+		}
+		res := CheckResult{Check: check.name, Status: "ok"}
+		problems, err := check.run()
+		switch {
+		case err != nil:
+			res.Status = "error"
+			res.Error = err.Error()
+			log.Warn().
+				Err(err).
+				Str("check", check.name).
+				Msg("Preflight check could not run")
+		case len(problems) > 0:
+			res.Status = "failed"
+			res.Problems = problems
+			failed = append(failed, check.name)
+			for _, p := range problems {
+				log.Error().
+					Str("check", check.name).
+					Msg(p)
+			}
+		}
+		results = append(results, res)
+	}
+
+	if len(failed) > 0 {
+		return results, fmt.Errorf("%w: %s", mitch.ErrPreflightFailed, strings.Join(failed, ", "))
+	}
+	return results, nil
+}
+
+// tableRef is a `database.table` a statement operates on
+type tableRef struct {
+	database, table string
+}
+
+func affectedTables(stmts []string, dbName string) []tableRef {
+	seen := make(map[tableRef]bool)
+	var refs []tableRef
+	for _, stmt := range stmts {
+		database, table := AffectedTable(stmt)
+		if table == "" {
+			continue
+		}
+		if database == "" {
+			database = dbName
+		}
+		ref := tableRef{database, table}
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// pendingMutations lists unfinished mutations on the tables about to be changed
+func (rr *Runner) pendingMutations(ctx context.Context, tables []tableRef) ([]string, error) {
+	var problems []string
+	for _, ref := range tables {
+		q := `
+			SELECT mutation_id, parts_to_do, latest_fail_reason
+			FROM system.mutations
+			WHERE NOT is_done AND database = $1 AND table = $2;
+		`
+		rows, err := rr.db.QueryContext(ctx, q, ref.database, ref.table)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var (
+				id, reason string
+				partsToDo  int64
+			)
+			if err := rows.Scan(&id, &partsToDo, &reason); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			p := fmt.Sprintf("%s.%s: mutation %s pending, %d parts remaining", ref.database, ref.table, id, partsToDo)
+			if reason != "" {
+				p += ", failing with: " + reason
+			}
+			problems = append(problems, p)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return problems, nil
+}
+
+// replicationBacklog lists replicated tables of the database whose replication
+// queue is longer than max
+func (rr *Runner) replicationBacklog(ctx context.Context, dbName string, max uint64) ([]string, error) {
+	q := `
+		SELECT table, count()
+		FROM system.replication_queue
+		WHERE database = $1
+		GROUP BY table
+		HAVING count() > $2
+		ORDER BY table;
+	`
+	return rr.queryProblems(ctx, q, []any{dbName, max}, func(scan func(...any) error) (string, error) {
+		var (
+			table string
+			size  uint64
+		)
+		if err := scan(&table, &size); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s.%s: %d entries in replication queue", dbName, table, size), nil
+	})
+}
+
+// readonlyReplicas lists replicated tables of the database in readonly mode,
+// eg. after losing their ZooKeeper session
+func (rr *Runner) readonlyReplicas(ctx context.Context, dbName string) ([]string, error) {
+	q := `
+		SELECT table
+		FROM system.replicas
+		WHERE database = $1 AND is_readonly
+		ORDER BY table;
+	`
+	return rr.queryProblems(ctx, q, []any{dbName}, func(scan func(...any) error) (string, error) {
+		var table string
+		if err := scan(&table); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s.%s: replica is readonly", dbName, table), nil
+	})
+}
+
+// lowDiskSpace lists disks with less than minRatio of their space free
+func (rr *Runner) lowDiskSpace(ctx context.Context, minRatio float64) ([]string, error) {
+	q := `SELECT name, free_space, total_space FROM system.disks ORDER BY name;`
+	return rr.queryProblems(ctx, q, nil, func(scan func(...any) error) (string, error) {
+		var (
+			name        string
+			free, total uint64
+		)
+		if err := scan(&name, &free, &total); err != nil {
+			return "", err
+		}
+		if total == 0 || float64(free)/float64(total) >= minRatio {
+			return "", nil
+		}
+		return fmt.Sprintf("disk %s: %d of %d bytes free, below %.0f%%", name, free, total, minRatio*100), nil
+	})
+}
+
+// missingGrants lists privileges stmts need that the current user and its
+// enabled roles are not granted
+func (rr *Runner) missingGrants(ctx context.Context, stmts []string, dbName string) ([]string, error) {
+	q := `
+		SELECT access_type, ifNull(database, ''), ifNull(table, ''), is_partial_revoke
+		FROM system.grants
+		WHERE user_name = currentUser()
+			OR role_name IN (SELECT role_name FROM system.enabled_roles);
+	`
+	rows, err := rr.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants Grants
+	for rows.Next() {
+		var (
+			g       Grant
+			revoked uint8
+		)
+		if err := rows.Scan(&g.AccessType, &g.Database, &g.Table, &revoked); err != nil {
+			return nil, err
+		}
+		g.Revoke = revoked == 1
+		grants = append(grants, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var problems []string
+	for _, stmt := range stmts {
+		privilege := RequiredPrivilege(stmt)
+		if privilege == "" {
+			continue
+		}
+		database, table := AffectedTable(stmt)
+		if database == "" {
+			database = dbName
+		}
+		if grants.Allow(privilege, database, table) {
+			continue
+		}
+		p := fmt.Sprintf("missing %s on %s.%s", privilege, database, orAll(table))
+		if !seen[p] {
+			seen[p] = true
+			problems = append(problems, p)
+		}
+	}
+	sort.Strings(problems)
+	return problems, nil
+}
+
+// queryProblems runs q and collects the non-empty problems describe returns for each row
+func (rr *Runner) queryProblems(ctx context.Context, q string, args []any, describe func(scan func(...any) error) (string, error)) ([]string, error) {
+	rows, err := rr.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		p, err := describe(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		if p != "" {
+			problems = append(problems, p)
+		}
+	}
+	return problems, rows.Err()
+}
+
+func orAll(name string) string {
+	if name == "" {
+		return "*"
+	}
+	return name
+}
+
+// Grant is a row of system.grants, an empty database or table grants all
+type Grant struct {
+	AccessType string
+	Database   string
+	Table      string
+	// Revoke is a partial revoke of the access type
+	Revoke bool
+}
+
+type Grants []Grant
+
+// privilegeParents are the access types that include an access type, after
+// ClickHouse's privilege hierarchy. Every access type is included in ALL.
+var privilegeParents = map[string][]string{
+	"ALTER ADD COLUMN":    {"ALTER COLUMN", "ALTER TABLE", "ALTER"},
+	"ALTER DROP COLUMN":   {"ALTER COLUMN", "ALTER TABLE", "ALTER"},
+	"ALTER MODIFY COLUMN": {"ALTER COLUMN", "ALTER TABLE", "ALTER"},
+	"ALTER RENAME COLUMN": {"ALTER COLUMN", "ALTER TABLE", "ALTER"},
+	"ALTER CLEAR COLUMN":  {"ALTER COLUMN", "ALTER TABLE", "ALTER"},
+	"ALTER ADD INDEX":     {"ALTER INDEX", "ALTER TABLE", "ALTER"},
+	"ALTER DROP INDEX":    {"ALTER INDEX", "ALTER TABLE", "ALTER"},
+	"ALTER UPDATE":        {"ALTER TABLE", "ALTER"},
+	"ALTER DELETE":        {"ALTER TABLE", "ALTER"},
+	"ALTER TABLE":         {"ALTER"},
+	"CREATE TABLE":        {"CREATE"},
+	"CREATE VIEW":         {"CREATE"},
+	"CREATE DICTIONARY":   {"CREATE"},
+	"CREATE DATABASE":     {"CREATE"},
+	"CREATE FUNCTION":     {"CREATE"},
+	"DROP TABLE":          {"DROP"},
+	"DROP VIEW":           {"DROP"},
+	"DROP DICTIONARY":     {"DROP"},
+	"DROP DATABASE":       {"DROP"},
+	"DROP FUNCTION":       {"DROP"},
+}
+
+// Allow reports whether the grants include privilege on database.table
+func (gs Grants) Allow(privilege, database, table string) bool {
+	accepted := append([]string{privilege, "ALL"}, privilegeParents[privilege]...)
+	allowed := false
+	for _, g := range gs {
+		if !g.covers(database, table) {
+			continue
+		}
+		for _, at := range accepted {
+			if g.AccessType != at {
+				continue
+			}
+			if g.Revoke {
+				return false
+			}
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+func (g Grant) covers(database, table string) bool {
+	if g.Database != "" && g.Database != database {
+		return false
+	}
+	// the table of eg. CREATE TABLE is not known, accept table grants too
+	return g.Table == "" || table == "" || g.Table == table
+}
+
+var rgxAlterPrivilege = regexp.MustCompile(`\b(ADD|DROP|MODIFY|RENAME|CLEAR) (COLUMN|INDEX)\b|\b(UPDATE|DELETE WHERE)\b`)
+
+// RequiredPrivilege returns the main ClickHouse access type stmt needs, or an
+// empty string when it is not recognised
+func RequiredPrivilege(stmt string) string {
+	norm := normaliseStatement(stmt)
+	if m := rgxCreate.FindStringSubmatch(norm); m != nil {
+		switch m[2] {
+		case "MATERIALIZED VIEW", "LIVE VIEW":
+			return "CREATE VIEW"
+		default:
+			return "CREATE " + m[2]
+		}
+	}
+	if m := rgxDrop.FindStringSubmatch(norm); m != nil {
+		if strings.HasPrefix(norm, "TRUNCATE") {
+			return "TRUNCATE"
+		}
+		return "DROP " + m[3]
+	}
+	switch {
+	case strings.HasPrefix(norm, "ALTER TABLE "):
+		m := rgxAlterPrivilege.FindStringSubmatch(norm)
+		switch {
+		case m == nil:
+			return "ALTER TABLE"
+		case m[3] == "UPDATE":
+			return "ALTER UPDATE"
+		case m[3] != "":
+			return "ALTER DELETE"
+		default:
+			return "ALTER " + m[1] + " " + m[2]
+		}
+	case strings.HasPrefix(norm, "INSERT "):
+		return "INSERT"
+	case strings.HasPrefix(norm, "OPTIMIZE "):
+		return "OPTIMIZE"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,46 @@
+package internal_test
+
+import (
+	"testing"
+
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequiredPrivilege(t *testing.T) {
+	as := assert.New(t)
+	as.Equal("CREATE TABLE", internal.RequiredPrivilege(sampleUpSQL))
+	as.Equal("DROP TABLE", internal.RequiredPrivilege(sampleDownSQL))
+	as.Equal("ALTER ADD COLUMN", internal.RequiredPrivilege(anotherUpSQL))
+	as.Equal("ALTER DELETE", internal.RequiredPrivilege("ALTER TABLE t DELETE WHERE c = 0"))
+	as.Equal("CREATE VIEW", internal.RequiredPrivilege("CREATE MATERIALIZED VIEW IF NOT EXISTS mv TO t AS SELECT 1"))
+	as.Equal("TRUNCATE", internal.RequiredPrivilege("TRUNCATE TABLE comments;"))
+	as.Equal("INSERT", internal.RequiredPrivilege("INSERT INTO t VALUES (1)"))
+	as.Equal("", internal.RequiredPrivilege("SELECT 1"))
+}
+
+func TestGrantsAllow(t *testing.T) {
+	t.Run("hierarchy", func(tt *testing.T) {
+		as := assert.New(tt)
+		grants := internal.Grants{
+			{AccessType: "ALTER TABLE", Database: "app"},
+			{AccessType: "INSERT", Database: "app", Table: "events"},
+		}
+		as.True(grants.Allow("ALTER ADD COLUMN", "app", "events"))
+		as.True(grants.Allow("INSERT", "app", "events"))
+		as.False(grants.Allow("INSERT", "app", "users"))
+		as.False(grants.Allow("ALTER ADD COLUMN", "analytics", "events"))
+		as.False(grants.Allow("DROP TABLE", "app", "events"))
+	})
+
+	t.Run("all with partial revoke", func(tt *testing.T) {
+		as := assert.New(tt)
+		grants := internal.Grants{
+			{AccessType: "ALL"},
+			{AccessType: "DROP", Database: "app", Revoke: true},
+		}
+		as.True(grants.Allow("CREATE TABLE", "app", ""))
+		as.False(grants.Allow("DROP TABLE", "app", "events"))
+		as.True(grants.Allow("DROP TABLE", "scratch", "events"))
+	})
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Report is a machine-readable record of a migration run
+type Report struct {
+	Target       string          `json:"target,omitempty"`
+	Direction    string          `json:"direction"`
+	StartVersion int64           `json:"start_version"`
+	EndVersion   int64           `json:"end_version"`
+	StartedAt    time.Time       `json:"started_at"`
+	FinishedAt   time.Time       `json:"finished_at"`
+	Versions     []VersionReport `json:"versions"`
+	Preflight    []CheckResult   `json:"preflight,omitempty"`
+	Retries      []RetryReport   `json:"retries,omitempty"`
+	Warnings     []string        `json:"warnings,omitempty"`
+	Error        string          `json:"error,omitempty"`
+}
+
+// VersionReport is the outcome of applying or rolling back a single version
+type VersionReport struct {
+	ID         int64             `json:"id"`
+	Source     string            `json:"source"`
+	Direction  string            `json:"direction"`
+	DurationMS float64           `json:"duration_ms"`
+	Statements []StatementReport `json:"statements"`
+	Error      string            `json:"error,omitempty"`
+	// FailedStatement is the 1-based position of the statement that failed
+	FailedStatement int `json:"failed_statement,omitempty"`
+}
+
+// StatementReport is the outcome of a single statement
+type StatementReport struct {
+	// Index is the 1-based position of the statement in its section
+	Index int `json:"index"`
+	// Class is safe, data-altering or destructive, see `Classify`
+	Class      string  `json:"class,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+	// RowsAffected is only reported where the server sends write progress,
+	// ie. over the native protocol
+	RowsAffected *uint64 `json:"rows_affected,omitempty"`
+	// Mutations are the IDs of the mutations the statement created and waited for
+	Mutations []string `json:"mutations,omitempty"`
+	// Batch is the ID of the batch the statement ran for in a batched version
+	Batch string `json:"batch,omitempty"`
+	// Retries is the number of times the statement was retried, see `WithRetry`
+	Retries int    `json:"retries,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RetryReport is a retry of a statement or a version table query after a
+// transient failure
+type RetryReport struct {
+	Operation string `json:"operation"`
+	// Attempt is the 1-based attempt the failure is retried in
+	Attempt   int     `json:"attempt"`
+	BackoffMS float64 `json:"backoff_ms"`
+	Error     string  `json:"error"`
+}
+
+func (d MigrationDirection) String() string {
+	if d == Down {
+		return "down"
+	}
+	return "up"
+}
+
+// NewReport starts a report for a run in direction
+func NewReport(direction MigrationDirection) *Report {
+	return &Report{
+		Direction: direction.String(),
+		StartedAt: time.Now().UTC(),
+		Versions:  []VersionReport{},
+	}
+}
+
+// Warn records a warning, eg. missing versions
+func (r *Report) Warn(format string, args ...any) {
+	if r == nil {
+		return
+	}
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// AddRetry records a retry after a transient failure
+func (r *Report) AddRetry(retry RetryReport) {
+	if r == nil {
+		return
+	}
+	r.Retries = append(r.Retries, retry)
+}
+
+// AddVersion records the outcome of a single version
+func (r *Report) AddVersion(vr VersionReport) {
+	if r == nil {
+		return
+	}
+	r.Versions = append(r.Versions, vr)
+}
+
+// Finish records the end version and error, if any, of the run
+func (r *Report) Finish(endVersion int64, err error) {
+	if r == nil {
+		return
+	}
+	r.EndVersion = endVersion
+	r.FinishedAt = time.Now().UTC()
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// WriteJSON writes a report, or a list of reports of a fan-out run, to w
+func WriteJSON[R *Report | []*Report](w io.Writer, report R) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,219 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/arhyth/mitch"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultRetryCodes are the ClickHouse error codes of transient failures
+var DefaultRetryCodes = []int32{
+	159, // TIMEOUT_EXCEEDED
+	209, // SOCKET_TIMEOUT
+	210, // NETWORK_ERROR
+	225, // NO_ZOOKEEPER
+	242, // TABLE_IS_READ_ONLY
+	252, // TOO_MANY_PARTS
+	999, // KEEPER_EXCEPTION, eg. an expired session during ON CLUSTER DDL
+}
+
+// RetryPolicy tells which failures of idempotent statements and bookkeeping
+// queries are retried and how long to wait in between
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt, less than 2 disables retries
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled for every further
+	// retry up to MaxBackoff. Up to half of it is randomised.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Codes are the retried ClickHouse error codes, network errors are
+	// always retried
+	Codes []int32
+}
+
+// DefaultRetryPolicy returns a policy of 3 attempts with a backoff of 1s up
+// to 30s on `DefaultRetryCodes`
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Second,
+		MaxBackoff:  30 * time.Second,
+		Codes:       DefaultRetryCodes,
+	}
+}
+
+// WithRetry retries idempotent statements, see `IsIdempotent`, and the
+// queries of the version table on transient failures. By default nothing is
+// retried.
+func WithRetry(policy RetryPolicy) RunnerOption {
+	return func(rr *Runner) {
+		rr.retry = policy
+	}
+}
+
+var rgxErrorCode = regexp.MustCompile(`\bCode: (\d+)\b`)
+
+// Retryable reports whether err is a network error or a ClickHouse error with
+// one of the policy's codes
+func (p RetryPolicy) Retryable(err error) bool {
+	if err == nil ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, mitch.ErrStatementTimeout) {
+		return false
+	}
+	if connectionLost(err) {
+		return true
+	}
+	code, ok := errorCode(err)
+	return ok && slices.Contains(p.Codes, code)
+}
+
+// errorCode returns the ClickHouse error code of err, from the exception of
+// the native protocol or from the message of the HTTP interface
+func errorCode(err error) (int32, bool) {
+	var ex *clickhouse.Exception
+	if errors.As(err, &ex) {
+		return ex.Code, true
+	}
+	m := rgxErrorCode.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0, false
+	}
+	code, perr := strconv.ParseInt(m[1], 10, 32)
+	return int32(code), perr == nil
+}
+
+// connectionLost reports whether err broke the connection it happened on
+func connectionLost(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.As(err, &netErr)
+}
+
+// backoff returns the wait before the nth retry
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < n && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// withRetry runs fn until it succeeds, fails with an error the policy does
+// not retry or runs out of attempts. reset, if any, runs before retrying a
+// failure that broke the connection. It returns the number of retries.
+func (rr *Runner) withRetry(ctx context.Context, op string, reset func() error, fn func() error) (int, error) {
+	var retries int
+	for {
+		err := fn()
+		if err == nil || retries+1 >= rr.retry.MaxAttempts || !rr.retry.Retryable(err) {
+			return retries, err
+		}
+		retries++
+		wait := rr.retry.backoff(retries)
+		log.Warn().
+			Err(err).
+			Str("operation", op).
+			Int("attempt", retries+1).
+			Int("max_attempts", rr.retry.MaxAttempts).
+			Dur("backoff", wait).
+			Msg("mitch: retrying after transient error")
+		rr.report.AddRetry(RetryReport{
+			Operation: op,
+			Attempt:   retries + 1,
+			BackoffMS: millis(wait),
+			Error:     err.Error(),
+		})
+
+		select {
+		case <-ctx.Done():
+			return retries, err
+		case <-time.After(wait):
+		}
+		if reset != nil && connectionLost(err) {
+			if rerr := reset(); rerr != nil {
+				return retries, errors.Join(err, rerr)
+			}
+		}
+	}
+}
+
+// insertOnce runs insert with retries like `withRetry`. An insert whose
+// response was lost may still have been written, and a second one would
+// duplicate the row, so a retry first asks recorded whether it is there.
+func (rr *Runner) insertOnce(ctx context.Context, op string, reset func() error, recorded func() (bool, error), insert func() error) error {
+	var attempted bool
+	_, err := rr.withRetry(ctx, op, reset, func() error {
+		if attempted {
+			ok, err := recorded()
+			if err != nil || ok {
+				return err
+			}
+		}
+		attempted = true
+		return insert()
+	})
+	return err
+}
+
+// anyRows reports whether the `SELECT count()` query counts any rows
+func (rr *Runner) anyRows(ctx context.Context, query string, args ...any) (bool, error) {
+	var count uint64
+	if err := rr.db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// runTx is the transaction a version runs in. ClickHouse does not roll back
+// statements, so a transaction whose connection broke is replaced by one on a
+// new connection to retry in.
+type runTx struct {
+	ctx context.Context
+	db  *sql.DB
+	*sql.Tx
+}
+
+func (rr *Runner) beginRunTx(ctx context.Context) (*runTx, error) {
+	tx, err := rr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &runTx{ctx: ctx, db: rr.db, Tx: tx}, nil
+}
+
+// renew replaces the transaction with one on a new connection
+func (rt *runTx) renew() error {
+	_ = rt.Tx.Rollback()
+	tx, err := rt.db.BeginTx(rt.ctx, nil)
+	if err != nil {
+		return err
+	}
+	rt.Tx = tx
+	return nil
+}
@@ -0,0 +1,172 @@
+package internal_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/arhyth/mitch"
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryable(t *testing.T) {
+	policy := internal.DefaultRetryPolicy()
+
+	t.Run("transient", func(tt *testing.T) {
+		as := assert.New(tt)
+		for _, err := range []error{
+			&clickhouse.Exception{Code: 252, Name: "DB::Exception", Message: "Too many parts (300)"},
+			fmt.Errorf("failed to execute: %w", &clickhouse.Exception{Code: 999, Message: "Session expired"}),
+			errors.New("sendQuery: [HTTP 500] response body: \"Code: 159. DB::Exception: Timeout exceeded: elapsed 5.1 seconds\""),
+			fmt.Errorf("read: %w", syscall.ECONNRESET),
+			io.ErrUnexpectedEOF,
+		} {
+			as.True(policy.Retryable(err), err.Error())
+		}
+	})
+
+	t.Run("permanent", func(tt *testing.T) {
+		as := assert.New(tt)
+		for _, err := range []error{
+			&clickhouse.Exception{Code: 62, Message: "Syntax error"},
+			errors.New("Code: 60. DB::Exception: Table default.events does not exist"),
+			context.Canceled,
+			fmt.Errorf("%w: after 1m0s: read: i/o timeout", mitch.ErrStatementTimeout),
+		} {
+			as.False(policy.Retryable(err), err.Error())
+		}
+	})
+
+	t.Run("codes", func(tt *testing.T) {
+		as := assert.New(tt)
+		policy := internal.RetryPolicy{MaxAttempts: 2, Codes: []int32{60}}
+		as.True(policy.Retryable(&clickhouse.Exception{Code: 60}))
+		as.False(policy.Retryable(&clickhouse.Exception{Code: 252}))
+	})
+}
+
+func TestWithRetry(t *testing.T) {
+	policy := internal.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, Codes: internal.DefaultRetryCodes}
+	tooManyParts := &clickhouse.Exception{Code: 252, Message: "Too many parts (300)"}
+
+	t.Run("attempt limit", func(tt *testing.T) {
+		as := assert.New(tt)
+		runner := internal.NewRunner(nil, nil, internal.WithRetry(policy))
+		var calls int
+		retries, err := internal.RunWithRetry(runner, context.Background(), "op", nil, func() error {
+			calls++
+			return tooManyParts
+		})
+		as.ErrorIs(err, tooManyParts)
+		as.Equal(3, calls)
+		as.Equal(2, retries)
+	})
+
+	t.Run("success", func(tt *testing.T) {
+		as := assert.New(tt)
+		runner := internal.NewRunner(nil, nil, internal.WithRetry(policy))
+		var calls int
+		retries, err := internal.RunWithRetry(runner, context.Background(), "op", nil, func() error {
+			if calls++; calls == 1 {
+				return tooManyParts
+			}
+			return nil
+		})
+		as.NoError(err)
+		as.Equal(1, retries)
+	})
+
+	t.Run("permanent", func(tt *testing.T) {
+		as := assert.New(tt)
+		runner := internal.NewRunner(nil, nil, internal.WithRetry(policy))
+		var calls int
+		_, err := internal.RunWithRetry(runner, context.Background(), "op", nil, func() error {
+			calls++
+			return &clickhouse.Exception{Code: 62, Message: "Syntax error"}
+		})
+		as.Error(err)
+		as.Equal(1, calls)
+	})
+
+	t.Run("cancelled", func(tt *testing.T) {
+		as := assert.New(tt)
+		slow := policy
+		slow.Backoff = time.Hour
+		runner := internal.NewRunner(nil, nil, internal.WithRetry(slow))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		var calls int
+		_, err := internal.RunWithRetry(runner, ctx, "op", nil, func() error {
+			calls++
+			return tooManyParts
+		})
+		as.ErrorIs(err, tooManyParts)
+		as.Equal(1, calls)
+	})
+
+	t.Run("reset on lost connection", func(tt *testing.T) {
+		as := assert.New(tt)
+		runner := internal.NewRunner(nil, nil, internal.WithRetry(policy))
+		var resets int
+		reset := func() error {
+			resets++
+			return nil
+		}
+		errs := []error{tooManyParts, fmt.Errorf("read: %w", syscall.ECONNRESET), driver.ErrBadConn}
+		var calls int
+		_, err := internal.RunWithRetry(runner, context.Background(), "op", reset, func() error {
+			calls++
+			return errs[calls-1]
+		})
+		as.ErrorIs(err, driver.ErrBadConn)
+		as.Equal(3, calls)
+		as.Equal(1, resets)
+	})
+}
+
+func TestInsertOnce(t *testing.T) {
+	policy := internal.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+
+	t.Run("written before the connection broke", func(tt *testing.T) {
+		as := assert.New(tt)
+		runner := internal.NewRunner(nil, nil, internal.WithRetry(policy))
+		var inserts, checks int
+		err := internal.RunInsertOnce(runner, context.Background(), "insert", nil,
+			func() (bool, error) {
+				checks++
+				return true, nil
+			},
+			func() error {
+				inserts++
+				return io.ErrUnexpectedEOF
+			},
+		)
+		as.NoError(err)
+		as.Equal(1, inserts)
+		as.Equal(1, checks)
+	})
+
+	t.Run("not written", func(tt *testing.T) {
+		as := assert.New(tt)
+		runner := internal.NewRunner(nil, nil, internal.WithRetry(policy))
+		var inserts int
+		err := internal.RunInsertOnce(runner, context.Background(), "insert", nil,
+			func() (bool, error) { return false, nil },
+			func() error {
+				if inserts++; inserts == 1 {
+					return io.ErrUnexpectedEOF
+				}
+				return nil
+			},
+		)
+		as.NoError(err)
+		as.Equal(2, inserts)
+	})
+}
@@ -0,0 +1,322 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	rgxEngineTTL      = regexp.MustCompile(`\sTTL\s(.+?)(?:\sSETTINGS\s|$)`)
+	rgxEngineSettings = regexp.MustCompile(`\sSETTINGS\s(.+)$`)
+)
+
+// Schema is a snapshot of the objects of a database as seen in
+// `system.tables`, `system.columns` and `system.data_skipping_indices`
+type Schema struct {
+	Tables map[string]*Table `json:"tables"`
+}
+
+type Table struct {
+	Name         string   `json:"name"`
+	Engine       string   `json:"engine"`
+	SortingKey   string   `json:"sorting_key,omitempty"`
+	PrimaryKey   string   `json:"primary_key,omitempty"`
+	PartitionKey string   `json:"partition_key,omitempty"`
+	TTL          string   `json:"ttl,omitempty"`
+	Settings     string   `json:"settings,omitempty"`
+	Columns      []Column `json:"columns"`
+	Indexes      []Index  `json:"indexes,omitempty"`
+	// CreateQuery is the normalised statement creating the table
+	CreateQuery string `json:"-"`
+}
+
+// IsView reports whether the table is a view, materialized view or dictionary,
+// whose columns follow from its definition rather than being altered directly
+func (t *Table) IsView() bool {
+	switch t.Engine {
+	case "View", "MaterializedView", "LiveView", "WindowView", "Dictionary":
+		return true
+	}
+	return false
+}
+
+type Column struct {
+	Name              string `json:"name"`
+	Type              string `json:"type"`
+	DefaultKind       string `json:"default_kind,omitempty"`
+	DefaultExpression string `json:"default_expression,omitempty"`
+}
+
+// Definition returns the column type and default as written in DDL, eg. `DateTime DEFAULT now()`
+func (c Column) Definition() string {
+	return strings.TrimSpace(strings.Join([]string{c.Type, c.DefaultKind, c.DefaultExpression}, " "))
+}
+
+type Index struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Expression  string `json:"expression"`
+	Granularity uint64 `json:"granularity"`
+}
+
+// Definition returns the index as written in DDL, eg. `id TYPE minmax GRANULARITY 1`
+func (i Index) Definition() string {
+	return fmt.Sprintf("%s TYPE %s GRANULARITY %d", i.Expression, i.Type, i.Granularity)
+}
+
+// SchemaChange is a single difference between two schemas
+type SchemaChange struct {
+	Table string `json:"table"`
+	// Kind is what changed: table, definition (of views), engine, column,
+	// index, sorting_key, primary_key, partition_key, ttl or settings
+	Kind string `json:"kind"`
+	// Name of the changed column or index
+	Name string `json:"name,omitempty"`
+	// Change is either added, removed or changed
+	Change string `json:"change"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+func (sc SchemaChange) String() string {
+	subject := sc.Kind
+	if sc.Name != "" {
+		subject = fmt.Sprintf("%s %s", sc.Kind, sc.Name)
+	}
+	if sc.Kind == "table" {
+		return fmt.Sprintf("table %s %s", sc.Table, sc.Change)
+	}
+
+	switch sc.Change {
+	case "added":
+		return fmt.Sprintf("table %s: %s added: %s", sc.Table, subject, sc.To)
+	case "removed":
+		return fmt.Sprintf("table %s: %s removed", sc.Table, subject)
+	default:
+		return fmt.Sprintf("table %s: %s changed from %q to %q", sc.Table, subject, sc.From, sc.To)
+	}
+}
+
+// SnapshotSchema reads the tables, views and dictionaries of dbName with their
+// columns and indexes. Tables named in exclude, eg. the version table, are left
+// out, as are the implicit inner tables of materialized views.
+func SnapshotSchema(ctx context.Context, db *sql.DB, dbName string, exclude ...string) (*Schema, error) {
+	skip := make(map[string]bool, len(exclude))
+	for _, name := range exclude {
+		skip[name] = true
+	}
+
+	tablesQ := `
+		SELECT name, engine, engine_full, sorting_key, primary_key, partition_key, create_table_query
+		FROM system.tables
+		WHERE database = $1 AND NOT is_temporary
+		ORDER BY name;
+	`
+	rows, err := db.QueryContext(ctx, tablesQ, dbName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	defer rows.Close()
+
+	schema := &Schema{Tables: make(map[string]*Table)}
+	for rows.Next() {
+		var (
+			t          Table
+			engineFull string
+		)
+		if err := rows.Scan(&t.Name, &t.Engine, &engineFull, &t.SortingKey, &t.PrimaryKey, &t.PartitionKey, &t.CreateQuery); err != nil {
+			return nil, fmt.Errorf("failed to scan tables result: %w", err)
+		}
+		if skip[t.Name] || strings.HasPrefix(t.Name, ".inner") {
+			continue
+		}
+		t.CreateQuery = NormaliseDDL(t.CreateQuery, dbName)
+		if m := rgxEngineTTL.FindStringSubmatch(engineFull); m != nil {
+			t.TTL = m[1]
+		}
+		if m := rgxEngineSettings.FindStringSubmatch(engineFull); m != nil {
+			t.Settings = m[1]
+		}
+		schema.Tables[t.Name] = &t
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	columnsQ := `
+		SELECT table, name, type, default_kind, default_expression
+		FROM system.columns
+		WHERE database = $1
+		ORDER BY table, position;
+	`
+	colRows, err := db.QueryContext(ctx, columnsQ, dbName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list columns: %w", err)
+	}
+	defer colRows.Close()
+
+	for colRows.Next() {
+		var (
+			table string
+			col   Column
+		)
+		if err := colRows.Scan(&table, &col.Name, &col.Type, &col.DefaultKind, &col.DefaultExpression); err != nil {
+			return nil, fmt.Errorf("failed to scan columns result: %w", err)
+		}
+		if t, ok := schema.Tables[table]; ok {
+			t.Columns = append(t.Columns, col)
+		}
+	}
+	if err := colRows.Err(); err != nil {
+		return nil, err
+	}
+
+	indexesQ := `
+		SELECT table, name, type_full, expr, granularity
+		FROM system.data_skipping_indices
+		WHERE database = $1
+		ORDER BY table, name;
+	`
+	idxRows, err := db.QueryContext(ctx, indexesQ, dbName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indexes: %w", err)
+	}
+	defer idxRows.Close()
+
+	for idxRows.Next() {
+		var (
+			table string
+			idx   Index
+		)
+		if err := idxRows.Scan(&table, &idx.Name, &idx.Type, &idx.Expression, &idx.Granularity); err != nil {
+			return nil, fmt.Errorf("failed to scan indexes result: %w", err)
+		}
+		if t, ok := schema.Tables[table]; ok {
+			t.Indexes = append(t.Indexes, idx)
+		}
+	}
+	if err := idxRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// Diff lists the changes that turn s into other
+func (s *Schema) Diff(other *Schema) []SchemaChange {
+	var changes []SchemaChange
+	for _, name := range sortedTableNames(s, other) {
+		from, inFrom := s.Tables[name]
+		to, inTo := other.Tables[name]
+		switch {
+		case !inFrom:
+			changes = append(changes, SchemaChange{Table: name, Kind: "table", Change: "added"})
+		case !inTo:
+			changes = append(changes, SchemaChange{Table: name, Kind: "table", Change: "removed"})
+		default:
+			changes = append(changes, from.diff(to)...)
+		}
+	}
+	return changes
+}
+
+func (t *Table) diff(other *Table) []SchemaChange {
+	var changes []SchemaChange
+	if t.IsView() || other.IsView() {
+		if t.CreateQuery != other.CreateQuery {
+			changes = append(changes, SchemaChange{Table: t.Name, Kind: "definition", Change: "changed", From: t.CreateQuery, To: other.CreateQuery})
+		}
+		return changes
+	}
+
+	props := []struct {
+		kind     string
+		from, to string
+	}{
+		{"engine", t.Engine, other.Engine},
+		{"sorting_key", t.SortingKey, other.SortingKey},
+		{"primary_key", t.PrimaryKey, other.PrimaryKey},
+		{"partition_key", t.PartitionKey, other.PartitionKey},
+		{"ttl", t.TTL, other.TTL},
+		{"settings", t.Settings, other.Settings},
+	}
+	for _, p := range props {
+		if p.from == p.to {
+			continue
+		}
+		change := "changed"
+		switch {
+		case p.from == "":
+			change = "added"
+		case p.to == "":
+			change = "removed"
+		}
+		changes = append(changes, SchemaChange{Table: t.Name, Kind: p.kind, Change: change, From: p.from, To: p.to})
+	}
+
+	fromCols := make(map[string]Column, len(t.Columns))
+	for _, col := range t.Columns {
+		fromCols[col.Name] = col
+	}
+	toCols := make(map[string]Column, len(other.Columns))
+	for _, col := range other.Columns {
+		toCols[col.Name] = col
+	}
+	for _, col := range t.Columns {
+		to, ok := toCols[col.Name]
+		switch {
+		case !ok:
+			changes = append(changes, SchemaChange{Table: t.Name, Kind: "column", Name: col.Name, Change: "removed", From: col.Definition()})
+		case col.Definition() != to.Definition():
+			changes = append(changes, SchemaChange{Table: t.Name, Kind: "column", Name: col.Name, Change: "changed", From: col.Definition(), To: to.Definition()})
+		}
+	}
+	for _, col := range other.Columns {
+		if _, ok := fromCols[col.Name]; !ok {
+			changes = append(changes, SchemaChange{Table: t.Name, Kind: "column", Name: col.Name, Change: "added", To: col.Definition()})
+		}
+	}
+
+	fromIdx := make(map[string]Index, len(t.Indexes))
+	for _, idx := range t.Indexes {
+		fromIdx[idx.Name] = idx
+	}
+	toIdx := make(map[string]Index, len(other.Indexes))
+	for _, idx := range other.Indexes {
+		toIdx[idx.Name] = idx
+	}
+	for _, idx := range t.Indexes {
+		to, ok := toIdx[idx.Name]
+		switch {
+		case !ok:
+			changes = append(changes, SchemaChange{Table: t.Name, Kind: "index", Name: idx.Name, Change: "removed", From: idx.Definition()})
+		case idx.Definition() != to.Definition():
+			changes = append(changes, SchemaChange{Table: t.Name, Kind: "index", Name: idx.Name, Change: "changed", From: idx.Definition(), To: to.Definition()})
+		}
+	}
+	for _, idx := range other.Indexes {
+		if _, ok := fromIdx[idx.Name]; !ok {
+			changes = append(changes, SchemaChange{Table: t.Name, Kind: "index", Name: idx.Name, Change: "added", To: idx.Definition()})
+		}
+	}
+	return changes
+}
+
+func sortedTableNames(schemas ...*Schema) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, s := range schemas {
+		for name := range s.Tables {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
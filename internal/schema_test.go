@@ -0,0 +1,44 @@
+package internal_test
+
+import (
+	"testing"
+
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaDiff(t *testing.T) {
+	before := &internal.Schema{Tables: map[string]*internal.Table{
+		"test_table": {
+			Name:   "test_table",
+			Engine: "MergeTree",
+			Columns: []internal.Column{
+				{Name: "TenantId", Type: "UInt8"},
+				{Name: "Created", Type: "DateTime", DefaultKind: "DEFAULT", DefaultExpression: "now()"},
+			},
+		},
+	}}
+	after := &internal.Schema{Tables: map[string]*internal.Table{
+		"test_table": {
+			Name:   "test_table",
+			Engine: "MergeTree",
+			Columns: []internal.Column{
+				{Name: "TenantId", Type: "UInt16"},
+				{Name: "Created", Type: "DateTime", DefaultKind: "DEFAULT", DefaultExpression: "now()"},
+				{Name: "NewField", Type: "UInt32"},
+			},
+		},
+		"comments": {Name: "comments", Engine: "MergeTree"},
+	}}
+
+	as := assert.New(t)
+	as.Empty(before.Diff(before))
+	changes := before.Diff(after)
+	as.Equal([]internal.SchemaChange{
+		{Table: "comments", Kind: "table", Change: "added"},
+		{Table: "test_table", Kind: "column", Name: "TenantId", Change: "changed", From: "UInt8", To: "UInt16"},
+		{Table: "test_table", Kind: "column", Name: "NewField", Change: "added", To: "UInt32"},
+	}, changes)
+	as.Equal(`table test_table: column TenantId changed from "UInt8" to "UInt16"`, changes[1].String())
+	as.Equal("table test_table: column NewField added: UInt32", changes[2].String())
+}
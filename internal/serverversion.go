@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/arhyth/mitch"
+	"github.com/rs/zerolog/log"
+)
+
+// ServerVersion is a dotted ClickHouse release, eg. 24.3.2.23
+type ServerVersion []int
+
+var rgxServerVersion = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*`)
+
+// ParseServerVersion parses the output of `SELECT version()`, ignoring any
+// suffix such as `-lts`
+func ParseServerVersion(s string) (ServerVersion, error) {
+	numeric := rgxServerVersion.FindString(strings.TrimSpace(s))
+	if numeric == "" {
+		return nil, fmt.Errorf("invalid ClickHouse version %q", s)
+	}
+	parts := strings.Split(numeric, ".")
+	sv := make(ServerVersion, 0, len(parts))
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ClickHouse version %q", s)
+		}
+		sv = append(sv, n)
+	}
+	return sv, nil
+}
+
+func (sv ServerVersion) String() string {
+	parts := make([]string, len(sv))
+	for i, n := range sv {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
+
+// compare compares sv with other on the components of other, so that
+// 24.3.2.23 equals 24.3
+func (sv ServerVersion) compare(other ServerVersion) int {
+	for i, n := range other {
+		var v int
+		if i < len(sv) {
+			v = sv[i]
+		}
+		switch {
+		case v < n:
+			return -1
+		case v > n:
+			return 1
+		}
+	}
+	return 0
+}
+
+// Constraint is a comma-separated list of version comparisons that must all
+// hold, eg. `>=24.3, <25`
+type Constraint struct {
+	raw   string
+	terms []constraintTerm
+}
+
+type constraintTerm struct {
+	op      string
+	version ServerVersion
+}
+
+// ParseConstraint parses a version constraint. Operators are >=, >, <=, <,
+// = and !=, a version without an operator must be equal.
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{raw: strings.TrimSpace(s)}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		op := "="
+		for _, candidate := range []string{">=", "<=", "!=", "==", ">", "<", "="} {
+			if strings.HasPrefix(part, candidate) {
+				op = candidate
+				part = strings.TrimSpace(strings.TrimPrefix(part, candidate))
+				break
+			}
+		}
+		if op == "==" {
+			op = "="
+		}
+		version, err := ParseServerVersion(part)
+		if err != nil || rgxServerVersion.FindString(part) != part {
+			return Constraint{}, fmt.Errorf("%w: ClickHouse version constraint %q", mitch.ErrInvalidAnnotation, s)
+		}
+		c.terms = append(c.terms, constraintTerm{op: op, version: version})
+	}
+	return c, nil
+}
+
+func (c Constraint) String() string {
+	return c.raw
+}
+
+// Allows reports whether sv satisfies every comparison of the constraint
+func (c Constraint) Allows(sv ServerVersion) bool {
+	for _, t := range c.terms {
+		cmp := sv.compare(t.version)
+		var ok bool
+		switch t.op {
+		case ">=":
+			ok = cmp >= 0
+		case ">":
+			ok = cmp > 0
+		case "<=":
+			ok = cmp <= 0
+		case "<":
+			ok = cmp < 0
+		case "!=":
+			ok = cmp != 0
+		default:
+			ok = cmp == 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Alternative is an up section used instead of the default one on servers
+// matching its constraint, from a `/* up clickhouse: <constraint>` block
+type Alternative struct {
+	Constraint string
+	Up         *SQL
+}
+
+// ServerVersion returns the version of the connected server
+func (rr *Runner) ServerVersion() (ServerVersion, error) {
+	return rr.serverVersionFunc()
+}
+
+// checkServer fails when the server does not satisfy the version's
+// `requires-clickhouse` directive
+func (rr *Runner) checkServer(ver Version) error {
+	req, ok := ver.Annotations.Get("requires-clickhouse")
+	if !ok {
+		return nil
+	}
+	constraint, err := ParseConstraint(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", ver.Source, err)
+	}
+	sv, err := rr.ServerVersion()
+	if err != nil {
+		return err
+	}
+	if !constraint.Allows(sv) {
+		return fmt.Errorf(
+			"%w: %s requires ClickHouse %s, server is %s",
+			mitch.ErrServerVersion, ver.Source, constraint, sv,
+		)
+	}
+	return nil
+}
+
+// upFor returns the up section of ver for the connected server, the first
+// alternative whose constraint the server satisfies or the default section
+func (rr *Runner) upFor(ver Version) (*SQL, error) {
+	if len(ver.Alternatives) == 0 {
+		return ver.Up, nil
+	}
+	sv, err := rr.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	for _, alt := range ver.Alternatives {
+		constraint, err := ParseConstraint(alt.Constraint)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ver.Source, err)
+		}
+		if constraint.Allows(sv) {
+			log.Debug().
+				Str("source", ver.Source).
+				Str("constraint", alt.Constraint).
+				Msg("Using alternative up section")
+			return alt.Up, nil
+		}
+	}
+	return ver.Up, nil
+}
@@ -0,0 +1,70 @@
+package internal_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConstraint(t *testing.T) {
+	reqrd := require.New(t)
+	as := assert.New(t)
+
+	sv, err := internal.ParseServerVersion("24.3.2.23")
+	reqrd.NoError(err)
+	lts, err := internal.ParseServerVersion("23.8.9.54-lts")
+	reqrd.NoError(err)
+	as.Equal("23.8.9.54", lts.String())
+
+	for constraint, want := range map[string][2]bool{
+		">=24.3":       {true, false},
+		">=23.8, <24":  {false, true},
+		"24.3":         {true, false},
+		"!= 24.3":      {false, true},
+		"<= 23.8.9.54": {false, true},
+		">23.8":        {true, false},
+	} {
+		c, err := internal.ParseConstraint(constraint)
+		reqrd.NoError(err, constraint)
+		as.Equal(want[0], c.Allows(sv), constraint)
+		as.Equal(want[1], c.Allows(lts), constraint)
+	}
+
+	_, err = internal.ParseConstraint(">= latest")
+	as.Error(err)
+	_, err = internal.ParseConstraint(">=24.3-lts")
+	as.Error(err)
+}
+
+func TestParseAlternatives(t *testing.T) {
+	reqrd := require.New(t)
+	as := assert.New(t)
+	content := "-- +mitch requires-clickhouse: >=23.8\n" +
+		"\n" +
+		"CREATE TABLE IF NOT EXISTS events (`Payload` JSON) ENGINE = MergeTree ORDER BY tuple();\n" +
+		"\n" +
+		"/* up clickhouse: <24.8\n" +
+		"CREATE TABLE IF NOT EXISTS events (`Payload` String) ENGINE = MergeTree ORDER BY tuple();\n" +
+		"*/\n" +
+		"\n" +
+		"/* rollback\n" +
+		"DROP TABLE IF EXISTS events;\n" +
+		"*/"
+	ver, err := internal.ParseMigration(strings.NewReader(content))
+	reqrd.NoError(err)
+
+	req, _ := ver.Annotations.Get("requires-clickhouse")
+	as.Equal(">=23.8", req)
+	reqrd.Len(ver.Up.Statements, 1)
+	as.Contains(ver.Up.Statements[0], "JSON")
+	reqrd.Len(ver.Alternatives, 1)
+	as.Equal("<24.8", ver.Alternatives[0].Constraint)
+	reqrd.Len(ver.Alternatives[0].Up.Statements, 1)
+	as.Contains(ver.Alternatives[0].Up.Statements[0], "String")
+	as.Equal([]int{6}, ver.Alternatives[0].Up.Lines)
+	as.Equal([]string{"DROP TABLE IF EXISTS events;"}, ver.Down.Statements)
+	as.Equal([]int{10}, ver.Down.Lines)
+}
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/arhyth/mitch"
+)
+
+// WithQuerySettings sets ClickHouse settings every statement runs with, eg.
+// `max_memory_usage`. A file or a statement overrides them key by key with a
+// `-- +mitch settings: key=value, ...` directive.
+func WithQuerySettings(settings map[string]string) RunnerOption {
+	return func(rr *Runner) {
+		rr.querySettings = settings
+	}
+}
+
+// WithStatementTimeout bounds how long a statement runs, by default it runs
+// until the server finishes or fails it. A statement overrides it with a
+// `-- +mitch timeout: <duration>` directive, as can a whole file.
+func WithStatementTimeout(timeout time.Duration) RunnerOption {
+	return func(rr *Runner) {
+		rr.statementTimeout = timeout
+	}
+}
+
+// ParseSettings parses a comma-separated list of `key=value` settings
+func ParseSettings(s string) (map[string]string, error) {
+	settings := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("%w: settings: expected key=value, got %q", mitch.ErrInvalidAnnotation, strings.TrimSpace(pair))
+		}
+		settings[key] = value
+	}
+	return settings, nil
+}
+
+// annotatedSettings merges the runner's settings with those of the file and
+// then the statement directives, later ones overriding earlier ones by key
+func annotatedSettings(def map[string]string, stmtAnns, fileAnns Annotations) (clickhouse.Settings, error) {
+	settings := make(clickhouse.Settings, len(def))
+	for k, v := range def {
+		settings[k] = settingValue(v)
+	}
+	for _, anns := range []Annotations{fileAnns, stmtAnns} {
+		for _, ann := range anns {
+			if ann.Key != "settings" {
+				continue
+			}
+			parsed, err := ParseSettings(ann.Value)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range parsed {
+				settings[k] = settingValue(v)
+			}
+		}
+	}
+	return settings, nil
+}
+
+// settingValue passes integers as such, older servers only accept numeric
+// settings in their binary form
+func settingValue(v string) any {
+	if n, err := strconv.Atoi(v); err == nil {
+		return n
+	}
+	return v
+}
+
+// StatementSettings returns the settings and timeout the statement at idx of
+// section runs with. A timeout sets `max_execution_time` a few seconds above
+// it, so the server stops the query too, unless a setting already sets it.
+func (rr *Runner) StatementSettings(ver Version, section *SQL, idx int) (clickhouse.Settings, time.Duration, error) {
+	stmtAnns := section.AnnotationsAt(idx)
+	timeout, err := annotatedDuration("timeout", rr.statementTimeout, stmtAnns, ver.Annotations)
+	if err != nil {
+		return nil, 0, err
+	}
+	settings, err := annotatedSettings(rr.querySettings, stmtAnns, ver.Annotations)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, ok := settings["max_execution_time"]; !ok && timeout > 0 {
+		settings["max_execution_time"] = int(math.Ceil(timeout.Seconds())) + 5
+	}
+	return settings, timeout, nil
+}
+
+// statementContext cancels ctx once timeout passes. The driver overwrites
+// `max_execution_time` of a context with a deadline, so the timeout is a timer
+// instead and the settings of `StatementSettings` reach the server as is.
+func statementContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	if timeout <= 0 {
+		return ctx, func() { cancel(nil) }
+	}
+	timer := time.AfterFunc(timeout, func() { cancel(mitch.ErrStatementTimeout) })
+	return ctx, func() {
+		timer.Stop()
+		cancel(nil)
+	}
+}
+
+// timeoutErr reports a passed statement timeout as ErrStatementTimeout, other
+// errors as is
+func timeoutErr(ctx context.Context, timeout time.Duration, err error) error {
+	if timeout > 0 && errors.Is(context.Cause(ctx), mitch.ErrStatementTimeout) {
+		return fmt.Errorf("%w: after %s: %v", mitch.ErrStatementTimeout, timeout, err)
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package internal_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/arhyth/mitch"
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSettings(t *testing.T) {
+	t.Run("pairs", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		settings, err := internal.ParseSettings("max_execution_time=3600, alter_sync = 2,")
+		reqrd.NoError(err)
+		reqrd.Equal(map[string]string{"max_execution_time": "3600", "alter_sync": "2"}, settings)
+	})
+
+	t.Run("invalid", func(tt *testing.T) {
+		as := assert.New(tt)
+		for _, s := range []string{"max_execution_time", "=1", "alter_sync="} {
+			_, err := internal.ParseSettings(s)
+			as.True(errors.Is(err, mitch.ErrInvalidAnnotation), s)
+		}
+	})
+
+	t.Run("directives", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		content := "-- +mitch settings: max_memory_usage=10000000000\n" +
+			"-- +mitch timeout: 1h\n" +
+			"\n" +
+			"-- +mitch settings: alter_sync=2\n" +
+			"-- +mitch timeout: 30m\n" +
+			"ALTER TABLE events UPDATE flag = 1 WHERE 1;"
+		ver, err := internal.ParseMigration(strings.NewReader(content))
+		reqrd.NoError(err)
+
+		timeout, _ := ver.Annotations.Get("timeout")
+		reqrd.Equal("1h", timeout)
+		settings, _ := ver.Up.AnnotationsAt(0).Get("settings")
+		reqrd.Equal("alter_sync=2", settings)
+		timeout, _ = ver.Up.AnnotationsAt(0).Get("timeout")
+		reqrd.Equal("30m", timeout)
+	})
+}
+
+func TestStatementSettings(t *testing.T) {
+	content := "-- +mitch settings: max_memory_usage=10000000000\n" +
+		"\n" +
+		"-- +mitch settings: max_execution_time=3600\n" +
+		"-- +mitch timeout: 30m\n" +
+		"ALTER TABLE events UPDATE flag = 1 WHERE 1;\n" +
+		"\n" +
+		"OPTIMIZE TABLE events FINAL;"
+	ver, err := internal.ParseMigration(strings.NewReader(content))
+	require.NoError(t, err)
+	runner := internal.NewRunner(nil, nil,
+		internal.WithQuerySettings(map[string]string{"alter_sync": "2", "max_memory_usage": "1"}),
+		internal.WithStatementTimeout(10*time.Second),
+	)
+
+	t.Run("annotated max_execution_time", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		settings, timeout, err := runner.StatementSettings(*ver, ver.Up, 0)
+		reqrd.NoError(err)
+		reqrd.Equal(30*time.Minute, timeout)
+		reqrd.Equal(clickhouse.Settings{
+			"alter_sync":         2,
+			"max_memory_usage":   10000000000,
+			"max_execution_time": 3600,
+		}, settings)
+	})
+
+	t.Run("from timeout", func(tt *testing.T) {
+		reqrd := require.New(tt)
+		settings, timeout, err := runner.StatementSettings(*ver, ver.Up, 1)
+		reqrd.NoError(err)
+		reqrd.Equal(10*time.Second, timeout)
+		reqrd.Equal(clickhouse.Settings{
+			"alter_sync":         2,
+			"max_memory_usage":   10000000000,
+			"max_execution_time": 15,
+		}, settings)
+	})
+}
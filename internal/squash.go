@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/arhyth/mitch"
+	"github.com/rs/zerolog/log"
+)
+
+// SupersededDir is the subdirectory squashed migration files are moved to.
+// Files in it are not collected as migrations.
+const SupersededDir = "superseded"
+
+// Squash writes a baseline migration to dir recreating the schema of stmts,
+// versioned as through, and moves the files of versions up to and including
+// through to the `superseded` subdirectory. It returns the baseline path.
+//
+// New databases apply the baseline instead of the squashed versions, while
+// databases that already applied them are considered up to date.
+func Squash(dir string, through int64, stmts []string) (string, error) {
+	entries, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		squashed []string
+		width    = 3
+	)
+	for _, path := range entries {
+		fname := filepath.Base(path)
+		id, err := ParseVersion(fname)
+		if err != nil {
+			continue
+		}
+		if w := len(rgxVerPrefix.FindString(fname)); w > width {
+			width = w
+		}
+		if id <= through {
+			squashed = append(squashed, fname)
+		}
+	}
+	if len(squashed) == 0 {
+		return "", fmt.Errorf("%w: no versions up to %d", mitch.ErrNothingToSquash, through)
+	}
+
+	buf := new(strings.Builder)
+	buf.WriteString(annotationPrefix + "baseline\n")
+	fmt.Fprintf(buf, "-- Baseline of versions up to %d, the squashed files are in %s/.\n", through, SupersededDir)
+	buf.WriteString("-- Only the schema is captured, data inserted by the squashed versions is not.\n\n")
+	// functions are server-wide, they are not part of the database schema and
+	// would fail to apply where they already exist
+	var schema []string
+	for _, st := range stmts {
+		if !rgxCreateFunction.MatchString(st) {
+			schema = append(schema, st)
+		}
+	}
+	writeStatements(buf, schema)
+
+	supersededDir := filepath.Join(dir, SupersededDir)
+	if err := os.MkdirAll(supersededDir, 0o755); err != nil {
+		return "", err
+	}
+	// the baseline is complete before any file moves, so a failure never
+	// leaves the squashed versions hidden without a baseline
+	baseline := filepath.Join(dir, fmt.Sprintf("%0*d_baseline.sql", width, through))
+	tmp := baseline + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.TrimSuffix(buf.String(), "\n")), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write baseline: %w", err)
+	}
+	for i, fname := range squashed {
+		if err := os.Rename(filepath.Join(dir, fname), filepath.Join(supersededDir, fname)); err != nil {
+			restoreSquashed(dir, supersededDir, squashed[:i])
+			_ = os.Remove(tmp)
+			return "", fmt.Errorf("failed to move squashed migration: %w", err)
+		}
+		log.Debug().
+			Str("file", fname).
+			Msg("moved squashed migration")
+	}
+	if err := os.Rename(tmp, baseline); err != nil {
+		restoreSquashed(dir, supersededDir, squashed)
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("failed to write baseline: %w", err)
+	}
+
+	return baseline, nil
+}
+
+// restoreSquashed moves files back from the superseded directory after a
+// failed squash
+func restoreSquashed(dir, supersededDir string, fnames []string) {
+	for _, fname := range fnames {
+		if err := os.Rename(filepath.Join(supersededDir, fname), filepath.Join(dir, fname)); err != nil {
+			log.Error().
+				Err(err).
+				Str("file", fname).
+				Msg("failed to restore squashed migration")
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package internal_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSquash(t *testing.T) {
+	reqrd := require.New(t)
+	as := assert.New(t)
+
+	dir := t.TempDir()
+	for _, fname := range []string{"001_init.sql", "002_users.sql", "003_posts.sql"} {
+		reqrd.NoError(os.WriteFile(filepath.Join(dir, fname), []byte(anotherUpSQL), 0o644))
+	}
+
+	path, err := internal.Squash(dir, 2, []string{
+		"CREATE FUNCTION plus_one AS (x) -> x + 1",
+		"CREATE TABLE test_table (`Id` UInt8) ENGINE = MergeTree ORDER BY Id",
+	})
+	reqrd.NoError(err)
+	as.Equal(filepath.Join(dir, "002_baseline.sql"), path)
+	as.FileExists(filepath.Join(dir, "003_posts.sql"))
+	as.NoFileExists(filepath.Join(dir, "001_init.sql"))
+	as.FileExists(filepath.Join(dir, internal.SupersededDir, "001_init.sql"))
+	as.FileExists(filepath.Join(dir, internal.SupersededDir, "002_users.sql"))
+
+	file, err := os.Open(path)
+	reqrd.NoError(err)
+	defer file.Close()
+	ver, err := internal.ParseMigration(file)
+	reqrd.NoError(err)
+	as.True(ver.IsBaseline())
+	reqrd.Len(ver.Up.Statements, 1)
+	as.Contains(ver.Up.Statements[0], "CREATE TABLE test_table")
+	as.NoFileExists(path + ".tmp")
+	as.Empty(ver.Down.Statements)
+
+	_, err = internal.Squash(dir, 1, nil)
+	as.Error(err)
+}
+
+func TestSquashFailedMove(t *testing.T) {
+	reqrd := require.New(t)
+	as := assert.New(t)
+
+	dir := t.TempDir()
+	for _, fname := range []string{"001_init.sql", "002_users.sql"} {
+		reqrd.NoError(os.WriteFile(filepath.Join(dir, fname), []byte(anotherUpSQL), 0o644))
+	}
+	// a directory in the way of the second file fails its move
+	reqrd.NoError(os.MkdirAll(filepath.Join(dir, internal.SupersededDir, "002_users.sql", "x"), 0o755))
+
+	_, err := internal.Squash(dir, 2, nil)
+	reqrd.Error(err)
+	as.FileExists(filepath.Join(dir, "001_init.sql"))
+	as.FileExists(filepath.Join(dir, "002_users.sql"))
+	as.NoFileExists(filepath.Join(dir, "002_baseline.sql"))
+	as.NoFileExists(filepath.Join(dir, "002_baseline.sql.tmp"))
+}
@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	rgxLineComment  = regexp.MustCompile(`(?m)--.*$`)
+	rgxBlockComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	rgxSpaces       = regexp.MustCompile(`\s+`)
+	rgxCreate       = regexp.MustCompile(`^CREATE (OR REPLACE |TEMPORARY )?(TABLE|VIEW|MATERIALIZED VIEW|LIVE VIEW|DICTIONARY|DATABASE|FUNCTION)\b`)
+	rgxDrop         = regexp.MustCompile(`^(DROP (TEMPORARY )?(TABLE|VIEW|DICTIONARY|DATABASE|FUNCTION)|TRUNCATE( TABLE)?)\b`)
+	rgxAlterTable   = regexp.MustCompile(`^ALTER TABLE \S+( ON CLUSTER \S+)? `)
+	rgxAlterClause  = regexp.MustCompile(` SETTINGS .*$`)
+	// rgxIdempotentAction matches ALTER actions that are a no-op once applied
+	rgxIdempotentAction = regexp.MustCompile(`^((ADD|DROP|RENAME) (COLUMN|INDEX|PROJECTION|CONSTRAINT|STATISTICS) IF (NOT )?EXISTS ` +
+		`|MODIFY (COLUMN|TTL|SETTING|COMMENT) |RESET SETTING |REMOVE TTL$|COMMENT COLUMN ` +
+		`|MATERIALIZE (COLUMN|INDEX|PROJECTION|TTL|STATISTICS)\b|CLEAR (COLUMN|INDEX|PROJECTION|STATISTICS) |DELETE WHERE )`)
+	// rgxNonDeterministic matches calls to functions whose result changes
+	// between runs, eg. `now()`
+	rgxNonDeterministic = regexp.MustCompile(`\b(NOW(64|INBLOCK)?|TODAY|YESTERDAY|RAND\w*|RANDOM\w*|GENERATEUUIDV\d|CURRENT_TIMESTAMP|ROWNUMBERINBLOCK|BLOCKNUMBER)\s*\(`)
+	rgxMutation         = regexp.MustCompile(`^ALTER TABLE .*\b(DELETE WHERE|UPDATE \S+ ?=)`)
+	// rgxMutationAction matches other ALTER actions that rewrite parts
+	rgxMutationAction = regexp.MustCompile(`\b(MATERIALIZE (COLUMN|INDEX|PROJECTION|TTL|STATISTICS)|MODIFY COLUMN|DROP COLUMN|CLEAR (COLUMN|INDEX|PROJECTION)|APPLY DELETED MASK)\b`)
+)
+
+// normaliseStatement strips comments and collapses whitespace of stmt and
+// upper cases it, for keyword matching only
+func normaliseStatement(stmt string) string {
+	return strings.ToUpper(stripComments(stmt))
+}
+
+// stripComments removes comments of stmt and collapses its whitespace
+func stripComments(stmt string) string {
+	stmt = rgxBlockComment.ReplaceAllString(stmt, " ")
+	stmt = rgxLineComment.ReplaceAllString(stmt, " ")
+	stmt = rgxSpaces.ReplaceAllString(stmt, " ")
+	return strings.TrimSpace(stmt)
+}
+
+// IsIdempotent reports whether running stmt again after it succeeded leaves
+// the database unchanged and does not fail, eg. `CREATE TABLE IF NOT EXISTS`.
+// Statements it does not recognise are not idempotent.
+func IsIdempotent(stmt string) bool {
+	norm := normaliseStatement(stmt)
+	switch {
+	case rgxCreate.MatchString(norm):
+		return strings.HasPrefix(norm, "CREATE OR REPLACE ") || strings.Contains(norm, " IF NOT EXISTS ")
+	case strings.HasPrefix(norm, "DROP "):
+		return strings.Contains(norm, " IF EXISTS ")
+	case strings.HasPrefix(norm, "TRUNCATE "):
+		return true
+	case strings.HasPrefix(norm, "ALTER "):
+		return alterIsIdempotent(norm)
+	case strings.HasPrefix(norm, "OPTIMIZE "),
+		strings.HasPrefix(norm, "SYSTEM "),
+		strings.HasPrefix(norm, "SELECT "),
+		strings.HasPrefix(norm, "SET "):
+		return true
+	default:
+		// eg. INSERT, RENAME and EXCHANGE
+		return false
+	}
+}
+
+// alterIsIdempotent reports whether every action of the normalised ALTER
+// statement is known to be idempotent. Others, eg. `ATTACH PARTITION FROM`,
+// `MOVE PARTITION TO TABLE` or `UPDATE`, which may read the columns it writes,
+// are not.
+func alterIsIdempotent(norm string) bool {
+	header := rgxAlterTable.FindString(norm)
+	if header == "" {
+		return false
+	}
+	actions := strings.TrimSuffix(norm[len(header):], ";")
+	actions = rgxAlterClause.ReplaceAllString(actions, "")
+	for _, action := range splitActions(actions) {
+		if !rgxIdempotentAction.MatchString(action) || rgxNonDeterministic.MatchString(action) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitActions splits the actions of an ALTER statement on the commas outside
+// of parentheses and quotes
+func splitActions(actions string) []string {
+	var (
+		parts []string
+		depth int
+		quote rune
+		start int
+	)
+	for i, r := range actions {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(actions[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(actions[start:]))
+}
+
+// CreatesMutation reports whether stmt runs as an asynchronous mutation, eg.
+// `ALTER TABLE ... DELETE/UPDATE`, `MATERIALIZE` or a lightweight `DELETE FROM`
+func CreatesMutation(stmt string) bool {
+	norm := normaliseStatement(stmt)
+	if strings.HasPrefix(norm, "DELETE FROM ") {
+		return true
+	}
+	return strings.HasPrefix(norm, "ALTER TABLE ") &&
+		(rgxMutation.MatchString(norm) || rgxMutationAction.MatchString(norm))
+}
+
+// StatementClass tells how much a statement can lose or change existing data
+type StatementClass int
+
+const (
+	// Safe statements do not change existing data, eg. CREATE or ADD COLUMN
+	Safe StatementClass = iota
+	// DataAltering statements change rows, eg. INSERT or ALTER UPDATE
+	DataAltering
+	// Destructive statements drop data, eg. DROP TABLE, TRUNCATE or DROP COLUMN
+	Destructive
+)
+
+func (sc StatementClass) String() string {
+	switch sc {
+	case DataAltering:
+		return "data-altering"
+	case Destructive:
+		return "destructive"
+	default:
+		return "safe"
+	}
+}
+
+var (
+	rgxDestructiveAlter = regexp.MustCompile(`\b(DROP (COLUMN|PARTITION|PART|DETACHED PARTITION|PROJECTION)|CLEAR COLUMN|DETACH PARTITION|REPLACE PARTITION|MOVE PARTITION)\b`)
+	rgxAlteringAlter    = regexp.MustCompile(`\b(DELETE WHERE|UPDATE \S+ ?=|(MODIFY COLUMN|MATERIALIZE (COLUMN|INDEX|PROJECTION|TTL)|ATTACH PARTITION)\b)`)
+	rgxTableName        = regexp.MustCompile("(?i)^(?:ALTER TABLE|TRUNCATE(?: TABLE)?|DROP (?:TEMPORARY )?(?:TABLE|VIEW|DICTIONARY)|DELETE FROM|INSERT INTO|OPTIMIZE TABLE|EXCHANGE TABLES)(?: IF EXISTS)? ([\\w.`\"]+)")
+	rgxDropDatabase     = regexp.MustCompile("(?i)^DROP DATABASE(?: IF EXISTS)? ([\\w`\"]+)")
+)
+
+// Classify tells whether stmt is safe, alters data or destroys data
+func Classify(stmt string) StatementClass {
+	norm := normaliseStatement(stmt)
+	switch {
+	case rgxDrop.MatchString(norm):
+		return Destructive
+	case strings.HasPrefix(norm, "ALTER "):
+		if rgxDestructiveAlter.MatchString(norm) {
+			return Destructive
+		}
+		if rgxAlteringAlter.MatchString(norm) {
+			return DataAltering
+		}
+		return Safe
+	case strings.HasPrefix(norm, "INSERT "),
+		strings.HasPrefix(norm, "DELETE "),
+		strings.HasPrefix(norm, "EXCHANGE "),
+		strings.HasPrefix(norm, "RENAME "),
+		strings.HasPrefix(norm, "OPTIMIZE "):
+		return DataAltering
+	default:
+		return Safe
+	}
+}
+
+// AffectedTable returns the `[database.]table` stmt operates on, or the
+// database of a DROP DATABASE with an empty table
+func AffectedTable(stmt string) (database, table string) {
+	// identifiers are case sensitive, keep the original case
+	norm := stripComments(stmt)
+	if m := rgxDropDatabase.FindStringSubmatch(norm); m != nil {
+		return unquoteIdent(m[1]), ""
+	}
+	m := rgxTableName.FindStringSubmatch(norm)
+	if m == nil {
+		return "", ""
+	}
+	name := m[1]
+	if db, tbl, ok := strings.Cut(name, "."); ok {
+		return unquoteIdent(db), unquoteIdent(tbl)
+	}
+	return "", unquoteIdent(name)
+}
+
+func unquoteIdent(name string) string {
+	return strings.Trim(name, "`\"")
+}
@@ -0,0 +1,93 @@
+package internal_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/arhyth/mitch"
+	"github.com/arhyth/mitch/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsIdempotent(t *testing.T) {
+	as := assert.New(t)
+	as.True(internal.IsIdempotent(sampleUpSQL))
+	as.True(internal.IsIdempotent(sampleDownSQL))
+	as.True(internal.IsIdempotent("ALTER TABLE t ADD COLUMN IF NOT EXISTS c UInt8, DROP COLUMN IF EXISTS d"))
+	as.True(internal.IsIdempotent("ALTER TABLE t DELETE WHERE c = 0 SETTINGS mutations_sync = 2"))
+	as.True(internal.IsIdempotent("CREATE OR REPLACE VIEW v AS SELECT 1"))
+	as.False(internal.IsIdempotent(anotherUpSQL))
+	as.True(internal.IsIdempotent("ALTER TABLE db.t ON CLUSTER c MODIFY COLUMN c UInt16, COMMENT COLUMN c 'count'"))
+	as.True(internal.IsIdempotent("ALTER TABLE t RENAME COLUMN IF EXISTS a TO b, MODIFY TTL d + INTERVAL 1 MONTH"))
+	as.True(internal.IsIdempotent("ALTER TABLE t MODIFY SETTING ttl_only_drop_parts = 1, MATERIALIZE INDEX idx"))
+	as.True(internal.IsIdempotent("ALTER TABLE t CLEAR COLUMN c IN PARTITION 202401"))
+	as.False(internal.IsIdempotent("ALTER TABLE t UPDATE c = c + 1 WHERE 1"))
+	as.False(internal.IsIdempotent("ALTER TABLE t ADD COLUMN IF NOT EXISTS c UInt8, ADD INDEX idx c TYPE minmax"))
+	as.False(internal.IsIdempotent("ALTER TABLE t RENAME COLUMN a TO b"))
+	as.False(internal.IsIdempotent("ALTER TABLE t ATTACH PARTITION 202401 FROM src"))
+	as.False(internal.IsIdempotent("ALTER TABLE t MOVE PARTITION 202401 TO TABLE dst"))
+	as.False(internal.IsIdempotent("ALTER TABLE t REPLACE PARTITION 202401 FROM src"))
+	as.False(internal.IsIdempotent("ALTER TABLE t DELETE WHERE created < now() - INTERVAL 1 DAY"))
+	as.False(internal.IsIdempotent("ALTER TABLE t DELETE WHERE rand() % 2 = 0"))
+	as.False(internal.IsIdempotent("ALTER TABLE t FREEZE"))
+	as.False(internal.IsIdempotent("INSERT INTO t VALUES (1)"))
+	as.False(internal.IsIdempotent("RENAME TABLE a TO b"))
+}
+
+func TestClassify(t *testing.T) {
+	as := assert.New(t)
+	as.Equal(internal.Safe, internal.Classify(sampleUpSQL))
+	as.Equal(internal.Safe, internal.Classify(anotherUpSQL))
+	as.Equal(internal.Destructive, internal.Classify(sampleDownSQL))
+	as.Equal(internal.Destructive, internal.Classify("TRUNCATE TABLE comments;"))
+	as.Equal(internal.Destructive, internal.Classify("ALTER TABLE t DROP COLUMN IF EXISTS c"))
+	as.Equal(internal.Destructive, internal.Classify("ALTER TABLE t DROP PARTITION 202401"))
+	as.Equal(internal.DataAltering, internal.Classify("ALTER TABLE t UPDATE c = 1 WHERE 1"))
+	as.Equal(internal.DataAltering, internal.Classify("-- seed\nINSERT INTO t VALUES (1)"))
+}
+
+func TestAffectedTable(t *testing.T) {
+	as := assert.New(t)
+	for stmt, want := range map[string][2]string{
+		sampleDownSQL:                           {"", "test_table"},
+		"TRUNCATE TABLE analytics.`Events`;":    {"analytics", "Events"},
+		"alter table Comments drop column c":    {"", "Comments"},
+		"DROP DATABASE IF EXISTS tenant_a;":     {"tenant_a", ""},
+		"CREATE TABLE t (c UInt8) ENGINE = Log": {"", ""},
+	} {
+		db, table := internal.AffectedTable(stmt)
+		as.Equal(want, [2]string{db, table}, stmt)
+	}
+}
+
+func TestDestructiveGuard(t *testing.T) {
+	as := assert.New(t)
+	var confirmed []internal.DestructiveChange
+	runner := internal.NewRunner(nil, nil, internal.WithDestructiveGuard(
+		func(ctx context.Context, change internal.DestructiveChange) error {
+			confirmed = append(confirmed, change)
+			return internal.RefuseDestructive(ctx, change)
+		},
+	))
+	ver := internal.Version{
+		ID:     3,
+		Source: "003_drop_function.sql",
+		Up:     &internal.SQL{Statements: []string{"SELECT 1;", "DROP FUNCTION IF EXISTS f;"}},
+	}
+
+	err := runner.Run(context.Background(), ver, internal.Up)
+	as.True(errors.Is(err, mitch.ErrDestructiveChange))
+	as.Len(confirmed, 1)
+	as.Equal(2, confirmed[0].Statements[0].Index)
+}
+
+func TestCreatesMutation(t *testing.T) {
+	as := assert.New(t)
+	as.True(internal.CreatesMutation("ALTER TABLE t DELETE WHERE c = 0"))
+	as.True(internal.CreatesMutation("ALTER TABLE db.t ON CLUSTER c UPDATE c = 1 WHERE 1"))
+	as.True(internal.CreatesMutation("ALTER TABLE t MATERIALIZE INDEX idx"))
+	as.True(internal.CreatesMutation("DELETE FROM t WHERE c = 0"))
+	as.False(internal.CreatesMutation(anotherUpSQL))
+	as.False(internal.CreatesMutation(sampleUpSQL))
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/arhyth/mitch"
+	"github.com/rs/zerolog/log"
+)
+
+// Verify applies every version up, then down, then up again and fails when the
+// schema after rolling back differs from the schema before applying, ie. the
+// rollback is incomplete or leaves objects behind. Irreversible versions are
+// only applied. It is meant to be run against a scratch database.
+func (rr *Runner) Verify(ctx context.Context) (err error) {
+	rr.report = NewReport(Up)
+	rr.report.Direction = "verify"
+	var current int64
+	defer func() {
+		rr.report.Finish(current, err)
+	}()
+
+	migration, err := rr.CollectMigration()
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(migration, func(i, j int) bool {
+		return migration[i].ID < migration[j].ID
+	})
+	if err := rr.MustVersionTable(ctx); err != nil {
+		return err
+	}
+
+	for _, ver := range migration {
+		before, err := rr.snapshot(ctx)
+		if err != nil {
+			return err
+		}
+		if err := rr.Run(ctx, ver, Up); err != nil {
+			return err
+		}
+		if rr.irreversible(ver) {
+			current = ver.ID
+			log.Warn().
+				Int64("version", ver.ID).
+				Str("source", ver.Source).
+				Msg("Skipped round trip of irreversible version")
+			rr.report.Warn("skipped round trip of irreversible version %d", ver.ID)
+			continue
+		}
+		if err := rr.Run(ctx, ver, Down); err != nil {
+			return err
+		}
+		after, err := rr.snapshot(ctx)
+		if err != nil {
+			return err
+		}
+		if changes := before.Diff(after); len(changes) > 0 {
+			diffs := make([]string, len(changes))
+			for i, ch := range changes {
+				diffs[i] = ch.String()
+				log.Error().
+					Int64("version", ver.ID).
+					Str("source", ver.Source).
+					Msg(diffs[i])
+			}
+			return fmt.Errorf(
+				"%w: %s: schema after rollback differs: %s",
+				mitch.ErrIrreversible,
+				ver.Source,
+				strings.Join(diffs, "; "),
+			)
+		}
+		if err := rr.Run(ctx, ver, Up); err != nil {
+			return fmt.Errorf("failed to re-apply %s after rollback: %w", ver.Source, err)
+		}
+		current = ver.ID
+
+		log.Info().
+			Int64("version", ver.ID).
+			Str("source", ver.Source).
+			Msg("mitch: verified round trip")
+	}
+
+	log.Info().Msgf("mitch: successfully verified migrations up to version: %d\n", current)
+	return nil
+}
+
+// snapshot returns the schema of the database, without mitch's own tables
+func (rr *Runner) snapshot(ctx context.Context) (*Schema, error) {
+	return SnapshotSchema(ctx, rr.db, rr.GetDBName(), rr.ExcludedTables()...)
+}
+
+// Schema returns a snapshot of the database schema, without mitch's own tables
+func (rr *Runner) Schema(ctx context.Context) (*Schema, error) {
+	return rr.snapshot(ctx)
+}
+
+// ExcludedTables lists mitch's own tables in the migrated database, the
+// version table and the checkpoints of batched versions
+func (rr *Runner) ExcludedTables() []string {
+	if rr.versionDB == "" || rr.versionDB == rr.GetDBName() {
+		return []string{rr.versionTable, rr.versionTable + batchTableSuffix}
+	}
+	return nil
+}
@@ -67,6 +67,8 @@ func TestBinaryRollback(t *testing.T) {
 			cli.envPath,
 			"--rollback",
 			"002_add_new_field_norollback.sql",
+			// 002 has no rollback section and is irreversible
+			"--force",
 		)
 		expectOut := "mitch: successfully rolled database back to version: 1"
 		reqrd.NoError(err)